@@ -1,14 +1,20 @@
 package api
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 
+	"github.com/energieip/common-components-go/pkg/dhvac"
 	pkg "github.com/energieip/common-components-go/pkg/service"
 	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
 	"github.com/gorilla/mux"
+	"github.com/romana/rlog"
 )
 
 func (api *API) getAPIs(w http.ResponseWriter, req *http.Request) {
@@ -22,9 +28,10 @@ func (api *API) getAPIs(w http.ResponseWriter, req *http.Request) {
 }
 
 //InitAPI start API connection
-func InitAPI(conf pkg.ServiceConfig) *API {
+func InitAPI(conf pkg.ServiceConfig, backend Backend) *API {
 	api := API{
 		EventsToBackend: make(chan map[string]interface{}),
+		backend:         backend,
 		certificate:     conf.InternalAPI.CertPath,
 		keyfile:         conf.InternalAPI.KeyPath,
 		apiIP:           conf.InternalAPI.IP,
@@ -54,7 +61,16 @@ func (api *API) getFunctions(w http.ResponseWriter, req *http.Request) {
 func (api *API) getV1Functions(w http.ResponseWriter, req *http.Request) {
 	api.setDefaultHeader(w)
 	apiV1 := "/v1.0"
-	functions := []string{apiV1 + "/device/new"}
+	functions := []string{apiV1 + "/device/new", apiV1 + "/mqtt/buffer", apiV1 + "/events/queues", apiV1 + "/driver/{mac}/timing",
+		apiV1 + "/firmware/updates", apiV1 + "/firmware/campaign", apiV1 + "/driver/{mac}/firmware",
+		apiV1 + "/driver/{mac}/loops", apiV1 + "/driver/{mac}/loop/{loop}", apiV1 + "/driver/{mac}/raw/{path}",
+		apiV1 + "/schedules", apiV1 + "/schedule/{id}", apiV1 + "/driver/{mac}/schedule", apiV1 + "/group/{group}/setting",
+		apiV1 + "/driver/{mac}/fallback", apiV1 + "/driver/{mac}/injection",
+		apiV1 + "/driver/{mac}/occupancy", apiV1 + "/driver/{mac}/occupancy/override",
+		apiV1 + "/driver/{mac}/window", apiV1 + "/windows", apiV1 + "/changeover", apiV1 + "/changeover/outside",
+		apiV1 + "/demand", apiV1 + "/driver/{mac}/condensation",
+		apiV1 + "/alarms", apiV1 + "/alarms/history", apiV1 + "/driver/{mac}/alarm/{type}/ack",
+		apiV1 + "/driver/{mac}/airquality"}
 	apiInfo := APIFunctions{
 		Functions: functions,
 	}
@@ -82,6 +98,572 @@ func (api *API) newDevice(w http.ResponseWriter, req *http.Request) {
 	w.Write([]byte("{}"))
 }
 
+func (api *API) getBufferStats(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.BufferStats(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDispatchStats(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.DispatchStats(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceTiming(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	timing, ok := api.backend.DeviceTiming(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(timing, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setDeviceTiming(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	if _, ok := api.backend.DeviceTiming(params["mac"]); !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	timing := core.HvacTiming{}
+	err = json.Unmarshal(body, &timing)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if timing.DumpFrequency < 0 || timing.RefreshFrequency < 0 {
+		api.sendError(w, APIErrorInvalidValue, "Invalid frequency", http.StatusBadRequest)
+		return
+	}
+	timing.Mac = params["mac"]
+	event := make(map[string]interface{})
+	event["timing"] = timing
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) getDeviceLoops(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	loops, ok := api.backend.DeviceLoops(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(loops, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setDeviceLoop(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	if _, ok := api.backend.DeviceTiming(params["mac"]); !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	loop, err := strconv.Atoi(params["loop"])
+	if err != nil || loop < 1 || loop > core.MaxLoops {
+		api.sendError(w, APIErrorInvalidValue, "Invalid regulation loop "+params["loop"], http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	conf := core.HvacLoopConf{}
+	err = json.Unmarshal(body, &conf)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conf.Mac = params["mac"]
+	conf.Loop = loop
+	event := make(map[string]interface{})
+	event["loopConf"] = conf
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) getFirmwareUpdates(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.FirmwareUpdates(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceFirmware(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	update, ok := api.backend.FirmwareUpdate(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "No firmware update for "+params["mac"], http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(update, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) updateDeviceFirmware(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	if _, ok := api.backend.DeviceTiming(params["mac"]); !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	request := core.FirmwareRequest{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &request)
+		if err != nil {
+			api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	request.Mac = params["mac"]
+	event := make(map[string]interface{})
+	event["firmwareUpdate"] = request
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) startCampaign(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	campaign := core.FirmwareCampaign{}
+	err = json.Unmarshal(body, &campaign)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	campaign.Macs = api.backend.StartCampaign(campaign.Macs)
+	inrec, _ := json.MarshalIndent(campaign, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getSchedules(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.Schedules(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setSchedule(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	schedule := core.Schedule{}
+	err = json.Unmarshal(body, &schedule)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = api.backend.SetSchedule(schedule)
+	if err != nil {
+		api.sendError(w, APIErrorInvalidValue, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+func (api *API) deleteSchedule(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	found, err := api.backend.DeleteSchedule(params["id"])
+	if !found {
+		api.sendError(w, APIErrorDeviceNotFound, "Schedule "+params["id"]+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.sendError(w, APIErrorDatabase, "Cannot save schedules "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+func (api *API) getDeviceSchedule(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	active, ok := api.backend.DeviceSchedule(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(active, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setGroup(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	group, err := strconv.Atoi(params["group"])
+	if err != nil {
+		api.sendError(w, APIErrorInvalidValue, "Invalid group "+params["group"], http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	conf := dhvac.HvacConf{}
+	err = json.Unmarshal(body, &conf)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := api.backend.GroupCommand(core.GroupCommand{
+		Group: group,
+		Conf:  conf,
+	})
+	inrec, _ := json.MarshalIndent(result, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceFallback(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	state, ok := api.backend.DeviceFallback(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceInjection(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	state, ok := api.backend.DeviceInjection(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceOccupancy(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	state, ok := api.backend.DeviceOccupancy(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setDeviceOccupancy(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	if _, ok := api.backend.DeviceTiming(params["mac"]); !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	delays := core.OccupancyDelaysRequest{}
+	err = json.Unmarshal(body, &delays)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if delays.OnDelay < 0 || delays.OffDelay < 0 || delays.EconomyDelay < 0 {
+		api.sendError(w, APIErrorInvalidValue, "Invalid delay", http.StatusBadRequest)
+		return
+	}
+	delays.Mac = params["mac"]
+	event := make(map[string]interface{})
+	event["occupancyDelays"] = delays
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) setOccupancyOverride(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	if _, ok := api.backend.DeviceTiming(params["mac"]); !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	override := core.OccupancyOverride{}
+	err = json.Unmarshal(body, &override)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if override.Mode < 0 || override.Duration < 0 {
+		api.sendError(w, APIErrorInvalidValue, "Invalid override", http.StatusBadRequest)
+		return
+	}
+	override.Mac = params["mac"]
+	event := make(map[string]interface{})
+	event["occupancyOverride"] = override
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) clearOccupancyOverride(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	if _, ok := api.backend.DeviceTiming(params["mac"]); !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	event := make(map[string]interface{})
+	event["occupancyOverride"] = core.OccupancyOverride{
+		Mac:  params["mac"],
+		Mode: -1,
+	}
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) getDeviceWindow(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	state, ok := api.backend.DeviceWindow(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getOpenWindows(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.OpenWindows(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getChangeover(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.Changeover(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setOutsideTemperature(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	outside := core.OutsideTemperature{}
+	err = json.Unmarshal(body, &outside)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	event := make(map[string]interface{})
+	event["outsideTemperature"] = outside
+	api.EventsToBackend <- event
+	w.Write([]byte("{}"))
+}
+
+func (api *API) getDemand(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.Demand(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) setDemand(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	request := core.DemandRequest{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state, err := api.backend.DemandResponse(request)
+	if err != nil {
+		api.sendError(w, APIErrorInvalidValue, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) cancelDemand(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	state, err := api.backend.DemandResponse(core.DemandRequest{})
+	if err != nil {
+		api.sendError(w, APIErrorInvalidValue, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceCondensation(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	state, ok := api.backend.DeviceCondensation(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getAlarms(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	inrec, _ := json.MarshalIndent(api.backend.Alarms(), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getAlarmHistory(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	query := req.URL.Query()
+	inrec, _ := json.MarshalIndent(api.backend.AlarmHistory(query.Get("mac"), query.Get("type")), "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) acknowledgeAlarm(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	ack := core.AlarmAck{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &ack)
+		if err != nil {
+			api.sendError(w, APIErrorBodyParsing, "Could not parse input format "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	ack.Mac = params["mac"]
+	ack.Type = params["type"]
+	alarm, ok := api.backend.AcknowledgeAlarm(ack)
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Alarm "+params["type"]+" not found on "+params["mac"], http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(alarm, "", "  ")
+	w.Write(inrec)
+}
+
+func (api *API) getDeviceAirQuality(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	state, ok := api.backend.DeviceAirQuality(params["mac"])
+	if !ok {
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+params["mac"]+" not found", http.StatusNotFound)
+		return
+	}
+	inrec, _ := json.MarshalIndent(state, "", "  ")
+	w.Write(inrec)
+}
+
+//authorized check the internal API password given in the EiPAccessToken header or cookie
+func (api *API) authorized(req *http.Request) bool {
+	if api.apiPassword == "" {
+		return false
+	}
+	token := req.Header.Get(TokenName)
+	if token == "" {
+		cookie, err := req.Cookie(TokenName)
+		if err != nil {
+			return false
+		}
+		token = cookie.Value
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.apiPassword)) == 1
+}
+
+//rawDevice forward a request to the controller API, every call is audited
+func (api *API) rawDevice(w http.ResponseWriter, req *http.Request) {
+	api.setDefaultHeader(w)
+	params := mux.Vars(req)
+	request := core.RawRequest{
+		Mac:         params["mac"],
+		Method:      req.Method,
+		Path:        strings.TrimPrefix(path.Clean("/"+params["path"]), "/"),
+		Query:       req.URL.RawQuery,
+		ContentType: req.Header.Get("Content-Type"),
+	}
+	audit := "Raw access from " + req.RemoteAddr + ": " + request.Method + " " + request.Mac + " /api/" + request.Path
+	if !api.authorized(req) {
+		rlog.Warn(audit + " refused")
+		api.sendError(w, APIErrorUnauthorized, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if _, ok := api.backend.DeviceTiming(request.Mac); !ok {
+		rlog.Warn(audit + " unknown device")
+		api.sendError(w, APIErrorDeviceNotFound, "Device "+request.Mac+" not found", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		api.sendError(w, APIErrorBodyParsing, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+	request.Body = body
+
+	resp, err := api.backend.RawRequest(request)
+	if err != nil {
+		rlog.Warn(audit + " failed: " + err.Error())
+		api.sendError(w, APIErrorUnreachable, "Cannot reach "+request.Mac+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	rlog.Info(audit + " (" + strconv.Itoa(len(body)) + " bytes) answered " + strconv.Itoa(resp.StatusCode))
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
 func (api *API) swagger() {
 	router := mux.NewRouter()
 	sh := http.StripPrefix("/swaggerui/", http.FileServer(http.Dir("/data/www/swaggerui/")))
@@ -93,6 +675,40 @@ func (api *API) swagger() {
 
 	//status
 	router.HandleFunc(apiV1+"/driver/new", api.newDevice).Methods("POST")
+	router.HandleFunc(apiV1+"/mqtt/buffer", api.getBufferStats).Methods("GET")
+	router.HandleFunc(apiV1+"/events/queues", api.getDispatchStats).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/timing", api.getDeviceTiming).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/timing", api.setDeviceTiming).Methods("POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/firmware", api.getDeviceFirmware).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/firmware", api.updateDeviceFirmware).Methods("POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/loops", api.getDeviceLoops).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/loop/{loop}", api.setDeviceLoop).Methods("POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/raw/{path:.*}", api.rawDevice).Methods("GET", "POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/schedule", api.getDeviceSchedule).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/fallback", api.getDeviceFallback).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/injection", api.getDeviceInjection).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/window", api.getDeviceWindow).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/condensation", api.getDeviceCondensation).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/airquality", api.getDeviceAirQuality).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/alarm/{type}/ack", api.acknowledgeAlarm).Methods("POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/occupancy", api.getDeviceOccupancy).Methods("GET")
+	router.HandleFunc(apiV1+"/driver/{mac}/occupancy", api.setDeviceOccupancy).Methods("POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/occupancy/override", api.setOccupancyOverride).Methods("POST")
+	router.HandleFunc(apiV1+"/driver/{mac}/occupancy/override", api.clearOccupancyOverride).Methods("DELETE")
+	router.HandleFunc(apiV1+"/firmware/updates", api.getFirmwareUpdates).Methods("GET")
+	router.HandleFunc(apiV1+"/firmware/campaign", api.startCampaign).Methods("POST")
+	router.HandleFunc(apiV1+"/group/{group}/setting", api.setGroup).Methods("POST")
+	router.HandleFunc(apiV1+"/windows", api.getOpenWindows).Methods("GET")
+	router.HandleFunc(apiV1+"/changeover", api.getChangeover).Methods("GET")
+	router.HandleFunc(apiV1+"/changeover/outside", api.setOutsideTemperature).Methods("POST")
+	router.HandleFunc(apiV1+"/demand", api.getDemand).Methods("GET")
+	router.HandleFunc(apiV1+"/alarms", api.getAlarms).Methods("GET")
+	router.HandleFunc(apiV1+"/alarms/history", api.getAlarmHistory).Methods("GET")
+	router.HandleFunc(apiV1+"/demand", api.setDemand).Methods("POST")
+	router.HandleFunc(apiV1+"/demand", api.cancelDemand).Methods("DELETE")
+	router.HandleFunc(apiV1+"/schedules", api.getSchedules).Methods("GET")
+	router.HandleFunc(apiV1+"/schedules", api.setSchedule).Methods("POST")
+	router.HandleFunc(apiV1+"/schedule/{id}", api.deleteSchedule).Methods("DELETE")
 
 	//unversionned API
 	router.HandleFunc("/versions", api.getAPIs).Methods("GET")
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
 	"github.com/romana/rlog"
 )
 
@@ -14,6 +15,7 @@ const (
 	APIErrorInvalidValue   = 4
 	APIErrorUnauthorized   = 5
 	APIErrorExpiredToken   = 6
+	APIErrorUnreachable    = 7
 
 	TokenName = "EiPAccessToken"
 )
@@ -24,8 +26,39 @@ type APIError struct {
 	Message string `json:"message"`
 }
 
+//Backend service accessors used by the API
+type Backend interface {
+	BufferStats() core.BufferStats
+	DispatchStats() core.DispatchStats
+	DeviceTiming(mac string) (*core.HvacTiming, bool)
+	DeviceLoops(mac string) ([]core.HvacLoopStatus, bool)
+	FirmwareUpdates() []core.FirmwareUpdate
+	FirmwareUpdate(mac string) (*core.FirmwareUpdate, bool)
+	StartCampaign(macs []string) []string
+	RawRequest(request core.RawRequest) (*core.RawResponse, error)
+	Schedules() []core.Schedule
+	SetSchedule(schedule core.Schedule) error
+	DeleteSchedule(id string) (bool, error)
+	DeviceSchedule(mac string) (*core.ActiveSchedule, bool)
+	GroupCommand(cmd core.GroupCommand) core.GroupResult
+	DeviceFallback(mac string) (*core.FallbackState, bool)
+	DeviceInjection(mac string) (*core.InjectionState, bool)
+	DeviceOccupancy(mac string) (*core.OccupancyState, bool)
+	DeviceWindow(mac string) (*core.WindowState, bool)
+	OpenWindows() []core.WindowState
+	Changeover() core.ChangeoverState
+	DemandResponse(request core.DemandRequest) (*core.DemandState, error)
+	Demand() core.DemandState
+	DeviceCondensation(mac string) (*core.CondensationState, bool)
+	Alarms() []core.Alarm
+	AlarmHistory(mac string, alarmType string) []core.AlarmEvent
+	AcknowledgeAlarm(ack core.AlarmAck) (*core.Alarm, bool)
+	DeviceAirQuality(mac string) (*core.AirQualityState, bool)
+}
+
 type API struct {
 	EventsToBackend chan map[string]interface{}
+	backend         Backend
 	certificate     string
 	keyfile         string
 	apiIP           string
@@ -0,0 +1,40 @@
+package core
+
+import "time"
+
+//Air quality limits
+const (
+	AirQualityCO2   = "co2"
+	AirQualityHumid = "hygrometryHigh"
+	AirQualityDry   = "hygrometryLow"
+)
+
+//AirQualityLimit state of a limit of a device
+//Since is the start of the pending transition, zero when the measure is back within the limit
+type AirQualityLimit struct {
+	Exceeded  bool      `json:"exceeded"`
+	Since     time.Time `json:"since"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+//AirQualityState air quality limits of a device
+//DamperPrevious is the fresh air damper minimum opening (%) of the device restored after the boost
+type AirQualityState struct {
+	Mac            string                     `json:"mac"`
+	CO2            int                        `json:"co2"`
+	Hygrometry     int                        `json:"hygrometry"`
+	Thresholds     AirQualityThresholds       `json:"thresholds"`
+	Limits         map[string]AirQualityLimit `json:"limits"`
+	DamperBoosted  bool                       `json:"damperBoosted"`
+	DamperPrevious *int                       `json:"damperPrevious,omitempty"`
+}
+
+//AirQualityEvent limit of a device exceeded or back to normal
+type AirQualityEvent struct {
+	Mac       string    `json:"mac"`
+	Limit     string    `json:"limit"`
+	Exceeded  bool      `json:"exceeded"`
+	Value     int       `json:"value"`
+	Threshold int       `json:"threshold"`
+	Date      time.Time `json:"date"`
+}
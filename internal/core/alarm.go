@@ -0,0 +1,72 @@
+package core
+
+import "time"
+
+//Alarm types
+const (
+	AlarmUnreachable      = "unreachable"
+	AlarmAuthFailure      = "authFailure"
+	AlarmTestMode         = "testMode"
+	AlarmCO2              = "co2"
+	AlarmSetpointDrift    = "setpointDrift"
+	AlarmFirmwareMismatch = "firmwareMismatch"
+	AlarmCondensation     = "condensation"
+	AlarmHygrometry       = "hygrometry"
+)
+
+//Alarm severities
+const (
+	SeverityCritical = "critical"
+	SeverityMajor    = "major"
+	SeverityMinor    = "minor"
+	SeverityWarning  = "warning"
+)
+
+//Alarm transitions
+const (
+	AlarmRaised       = "raised"
+	AlarmCleared      = "cleared"
+	AlarmAcknowledged = "acknowledged"
+)
+
+//DefaultSeverities severity of each alarm type
+func DefaultSeverities() map[string]string {
+	return map[string]string{
+		AlarmUnreachable:      SeverityMajor,
+		AlarmAuthFailure:      SeverityMajor,
+		AlarmTestMode:         SeverityWarning,
+		AlarmCO2:              SeverityMinor,
+		AlarmSetpointDrift:    SeverityMinor,
+		AlarmFirmwareMismatch: SeverityWarning,
+		AlarmCondensation:     SeverityCritical,
+		AlarmHygrometry:       SeverityMinor,
+	}
+}
+
+//Alarm alarm of a device, it is kept until cleared and acknowledged
+type Alarm struct {
+	Mac            string    `json:"mac"`
+	Type           string    `json:"type"`
+	Severity       string    `json:"severity"`
+	Message        string    `json:"message"`
+	Active         bool      `json:"active"`
+	RaisedAt       time.Time `json:"raisedAt"`
+	ClearedAt      time.Time `json:"clearedAt"`
+	Acknowledged   bool      `json:"acknowledged"`
+	AcknowledgedAt time.Time `json:"acknowledgedAt"`
+	AcknowledgedBy string    `json:"acknowledgedBy,omitempty"`
+}
+
+//AlarmEvent transition of an alarm
+type AlarmEvent struct {
+	Transition string    `json:"transition"`
+	Date       time.Time `json:"date"`
+	Alarm      Alarm     `json:"alarm"`
+}
+
+//AlarmAck acknowledgement of an alarm of a device
+type AlarmAck struct {
+	Mac  string `json:"mac"`
+	Type string `json:"type"`
+	By   string `json:"by"`
+}
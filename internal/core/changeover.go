@@ -0,0 +1,20 @@
+package core
+
+import "time"
+
+//OutsideTemperature outside temperature fed by the server, in tenth of °C
+type OutsideTemperature struct {
+	Temperature int `json:"temperature"`
+}
+
+//ChangeoverState bridge heat/cool changeover
+//Mode is unset until the first outside temperature, Devices holds the last result of each device
+type ChangeoverState struct {
+	Enabled   bool                `json:"enabled"`
+	Mode      *int                `json:"mode,omitempty"`
+	Outside   *int                `json:"outside,omitempty"`
+	OutsideAt time.Time           `json:"outsideAt"`
+	Stale     bool                `json:"stale"`
+	ChangedAt time.Time           `json:"changedAt"`
+	Devices   []GroupDeviceResult `json:"devices"`
+}
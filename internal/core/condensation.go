@@ -0,0 +1,17 @@
+package core
+
+import "time"
+
+//CondensationState condensation protection of a device
+//DewPoint (tenth of °C) is computed from the injected temperature and hygrometry, Previous holds
+//the heat/cool mode restored when the alarm clears after the cooling was switched off
+type CondensationState struct {
+	Mac        string    `json:"mac"`
+	Alarm      bool      `json:"alarm"`
+	DewSensor  bool      `json:"dewSensor"`
+	DewPoint   *int      `json:"dewPoint,omitempty"`
+	RaisedAt   time.Time `json:"raisedAt"`
+	ClearedAt  time.Time `json:"clearedAt"`
+	CoolingOff bool      `json:"coolingOff"`
+	Previous   *int      `json:"previous,omitempty"`
+}
@@ -0,0 +1,444 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+)
+
+const (
+	DefaultBufferSize = 1000
+	DefaultQueueSize  = 32
+
+	DefaultHeartbeat           = 120000 //in milliseconds
+	DefaultTemperatureDeadband = 5      //0.5°C
+	DefaultCO2Deadband         = 50     //ppm
+	DefaultHygroDeadband       = 20     //2%
+
+	DefaultTftpServer         = "10.0.0.2"
+	DefaultUpdateConcurrency  = 2
+	DefaultUpdatePollInterval = 15000  //in milliseconds
+	DefaultUpdateTimeout      = 600000 //in milliseconds
+
+	DefaultTftpPort = 69
+	DefaultTftpRoot = "/var/lib/energieip-swh200-rest2mqtt/firmware"
+
+	DefaultModbusPort    = 502
+	DefaultModbusUnitID  = 1
+	DefaultModbusTimeout = 2000 //in milliseconds
+	DefaultRegisterMap   = "/etc/energieip-swh200-rest2mqtt/modbus.json"
+
+	DefaultSchedules = "/var/lib/energieip-swh200-rest2mqtt/schedules.json"
+
+	DefaultRefreshFrequency = 30000 //in milliseconds
+	DefaultTimings          = "/var/lib/energieip-swh200-rest2mqtt/timings.json"
+
+	DefaultGroupConcurrency = 4
+	DefaultGroupTimeout     = 30000 //in milliseconds
+
+	DefaultFallbackTimeout = 900000 //in milliseconds
+
+	DefaultInjectionHeartbeat = 300000  //in milliseconds
+	DefaultInjectionMaxAge    = 1800000 //in milliseconds
+
+	DefaultOccupancyOnDelay      = 60000    //in milliseconds
+	DefaultOccupancyOffDelay     = 900000   //in milliseconds
+	DefaultOccupancyEconomyDelay = 14400000 //in milliseconds
+
+	DefaultWindowDebounce        = 5000   //in milliseconds
+	DefaultWindowProtectionDelay = 120000 //in milliseconds
+
+	DefaultChangeoverHeatBelow  = 150     //15°C
+	DefaultChangeoverCoolAbove  = 220     //22°C
+	DefaultChangeoverHysteresis = 10      //1°C
+	DefaultChangeoverMinDwell   = 3600000 //in milliseconds
+	DefaultChangeoverMaxAge     = 1800000 //in milliseconds
+
+	DefaultDewPointHysteresis = 10 //1°C
+
+	DefaultAlarmHistory   = 500
+	DefaultAlarmCO2Max    = 1500 //ppm
+	DefaultDriftTolerance = 5    //0.5°C
+
+	DefaultCO2Hysteresis      = 100    //ppm
+	DefaultHygroHysteresis    = 50     //5%
+	DefaultAirQualityDuration = 300000 //in milliseconds
+
+	OverflowDropOldest = "dropOldest"
+	OverflowDropNewest = "dropNewest"
+)
+
+//BridgeConfig rest2mqtt specific configuration
+//It is read from the "rest2mqtt" section of the service configuration file
+type BridgeConfig struct {
+	Mqtt         MqttConfig         `json:"mqtt"`
+	Dispatch     DispatchConfig     `json:"dispatch"`
+	Publish      PublishConfig      `json:"publish"`
+	Firmware     FirmwareConfig     `json:"firmware"`
+	Tftp         TftpConfig         `json:"tftp"`
+	Modbus       ModbusConfig       `json:"modbus"`
+	Mapping      string             `json:"mapping"`   //optional field mapping file
+	Schedules    string             `json:"schedules"` //occupancy schedules file
+	Timing       TimingConfig       `json:"timing"`
+	Groups       GroupConfig        `json:"groups"`
+	Fallback     FallbackConfig     `json:"fallback"`
+	Injection    InjectionConfig    `json:"injection"`
+	Occupancy    OccupancyConfig    `json:"occupancy"`
+	Window       WindowConfig       `json:"window"`
+	Changeover   ChangeoverConfig   `json:"changeover"`
+	Demand       DemandConfig       `json:"demand"`
+	Condensation CondensationConfig `json:"condensation"`
+	Alarms       AlarmConfig        `json:"alarms"`
+	AirQuality   AirQualityConfig   `json:"airQuality"`
+}
+
+//MqttConfig topics and delivery policy on the local broker
+type MqttConfig struct {
+	TopicPrefix string       `json:"topicPrefix"`
+	BufferSize  int          `json:"bufferSize"`
+	Hello       MqttDelivery `json:"hello"`
+	Status      MqttDelivery `json:"status"`
+	Ack         MqttDelivery `json:"ack"`
+	Events      MqttDelivery `json:"events"`
+	Commands    MqttDelivery `json:"commands"`
+}
+
+//TimingConfig default polling period of the devices, the per device periods are saved in File
+type TimingConfig struct {
+	RefreshFrequency int    `json:"refreshFrequency"` //in milliseconds
+	File             string `json:"file"`
+}
+
+//MqttDelivery QoS and retain flag for a message class
+type MqttDelivery struct {
+	Qos    byte `json:"qos"`
+	Retain bool `json:"retain"`
+}
+
+//DispatchConfig per device event queues
+type DispatchConfig struct {
+	QueueSize int    `json:"queueSize"`
+	Overflow  string `json:"overflow"` //dropOldest or dropNewest
+}
+
+//PublishConfig change driven status publication
+//Temperatures and hygrometry deadbands are in tenth
+type PublishConfig struct {
+	Heartbeat           int `json:"heartbeat"` //in milliseconds
+	TemperatureDeadband int `json:"temperatureDeadband"`
+	CO2Deadband         int `json:"co2Deadband"`
+	HygroDeadband       int `json:"hygroDeadband"`
+}
+
+//FirmwareConfig firmware update campaigns
+//Targets gives the expected software version per product type
+//Pinned gives a version per device mac address, it overrides the product target
+type FirmwareConfig struct {
+	TftpServer   string              `json:"tftpServer"`
+	Targets      map[string]string   `json:"targets"`
+	Pinned       map[string]string   `json:"pinned"`
+	AutoUpdate   bool                `json:"autoUpdate"`
+	Windows      []MaintenanceWindow `json:"windows"`
+	Concurrency  int                 `json:"concurrency"`
+	PollInterval int                 `json:"pollInterval"` //in milliseconds
+	Timeout      int                 `json:"timeout"`      //in milliseconds
+}
+
+//MaintenanceWindow period starting on each cron match and lasting Duration minutes
+type MaintenanceWindow struct {
+	Cron     string `json:"cron"`
+	Duration int    `json:"duration"`
+}
+
+//TftpConfig built-in read-only TFTP server
+//Interface is either an interface name or an IP address
+type TftpConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Interface string `json:"interface"`
+	Port      int    `json:"port"`
+	Root      string `json:"root"`
+}
+
+//ModbusConfig Modbus TCP fallback for controllers without the REST firmware
+type ModbusConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Port        int    `json:"port"`
+	UnitID      byte   `json:"unitID"`
+	Timeout     int    `json:"timeout"` //in milliseconds
+	RegisterMap string `json:"registerMap"`
+}
+
+//GroupConfig group commands fan-out
+//Timeout is the time given to each device to apply the command, values not above 0 are replaced by the defaults
+type GroupConfig struct {
+	Concurrency int `json:"concurrency"`
+	Timeout     int `json:"timeout"` //in milliseconds
+}
+
+//FallbackConfig autonomous mode entered when a device receives no setting for Timeout
+//TemperatureSelection selects the internal sensor in the regulation setup, the mode and
+//setpoints (tenth of °C) replace the ones fed by the server, unset values are left unchanged
+type FallbackConfig struct {
+	Enabled              bool `json:"enabled"`
+	Timeout              int  `json:"timeout"` //in milliseconds
+	TemperatureSelection *int `json:"temperatureSelection,omitempty"`
+	ScheduleAction
+}
+
+//InjectionConfig age of the measures injected by the server
+//Measures younger than MaxAge are written again every Heartbeat, older ones are flagged stale
+//and no longer written. WindowHeartBeat (in seconds) is sent with them so that the controller
+//stops using a measure which is not refreshed
+type InjectionConfig struct {
+	Heartbeat       int  `json:"heartbeat"` //in milliseconds, 0 disables the re-send
+	MaxAge          int  `json:"maxAge"`    //in milliseconds
+	WindowHeartBeat *int `json:"windowHeartBeat,omitempty"`
+}
+
+//OccupancyConfig presence to occupancy mode engine, the delays are the default ones of the devices
+type OccupancyConfig struct {
+	Enabled bool `json:"enabled"`
+	OccupancyDelays
+}
+
+//WindowConfig window contact interlock
+//A contact is taken into account once stable for Debounce, the mode and setpoints (tenth of °C)
+//are applied when the window stays open for ProtectionDelay
+type WindowConfig struct {
+	Enabled         bool `json:"enabled"`
+	Debounce        int  `json:"debounce"`        //in milliseconds
+	ProtectionDelay int  `json:"protectionDelay"` //in milliseconds
+	ScheduleAction
+}
+
+//ChangeoverConfig heat/cool changeover driven by the outside temperature (tenth of °C)
+//HEAT is applied below HeatBelow, COOL above CoolAbove and AUTO in between. A mode is left once
+//the temperature went back past its threshold by Hysteresis and not before MinDwell. An outside
+//temperature older than MaxAge keeps the current mode. Groups restricts the switched devices,
+//every configured one when empty
+type ChangeoverConfig struct {
+	Enabled    bool  `json:"enabled"`
+	Groups     []int `json:"groups"`
+	HeatBelow  int   `json:"heatBelow"`
+	CoolAbove  int   `json:"coolAbove"`
+	Hysteresis int   `json:"hysteresis"`
+	MinDwell   int   `json:"minDwell"` //in milliseconds
+	MaxAge     int   `json:"maxAge"`   //in milliseconds
+}
+
+//DemandConfig demand response levels, the level 1 being the first one
+type DemandConfig struct {
+	Levels []DemandLevel `json:"levels"`
+}
+
+//DemandLevel load shedding of a demand response level
+//Offset (tenth of °C) lowers the heat setpoints and raises the cool ones, Economy forces the
+//economy occupancy mode
+type DemandLevel struct {
+	Offset  int  `json:"offset"`
+	Economy bool `json:"economy"`
+}
+
+//CondensationConfig condensation protection
+//The alarm is raised by the dew sensor of the controller or, when DewPointMax (tenth of °C) is
+//set, by the dew point of the injected temperature and hygrometry reaching it. It clears once the
+//dew point is Hysteresis below. CoolingOff switches off a cooling controller until it clears
+type CondensationConfig struct {
+	Enabled     bool `json:"enabled"`
+	DewPointMax *int `json:"dewPointMax,omitempty"`
+	Hysteresis  int  `json:"hysteresis"`
+	CoolingOff  bool `json:"coolingOff"`
+}
+
+//AlarmConfig device alarms
+//History is the number of kept transitions. CO2Max (ppm, 0 disables it) raises the CO2 alarm,
+//DriftTolerance (tenth of °C) the drift of the setpoints from the last ones set by the server.
+//Severities overrides the severity of alarm types
+type AlarmConfig struct {
+	History        int               `json:"history"`
+	CO2Max         int               `json:"co2Max"`
+	DriftTolerance int               `json:"driftTolerance"`
+	Severities     map[string]string `json:"severities"`
+}
+
+//AirQualityConfig CO2 and hygrometry limits
+//The thresholds of a device are the ones of its mac address, else of its group, else the default
+//ones. DamperBoost (%) is the minimum fresh air damper opening set while the CO2 limit is exceeded,
+//the opening read from the device before is set back afterwards, DamperNormal when it is unknown
+type AirQualityConfig struct {
+	Enabled bool `json:"enabled"`
+	AirQualityThresholds
+	Groups       map[string]AirQualityThresholds `json:"groups"`
+	Devices      map[string]AirQualityThresholds `json:"devices"`
+	DamperBoost  *int                            `json:"damperBoost,omitempty"`
+	DamperNormal int                             `json:"damperNormal"`
+}
+
+//AirQualityThresholds CO2 (ppm) and hygrometry (tenth of %) limits, 0 disables a limit
+//A limit is exceeded once the measure stays beyond it for Duration and back to normal once it
+//stays Hysteresis within for Duration
+type AirQualityThresholds struct {
+	CO2Max          int `json:"co2Max"`
+	CO2Hysteresis   int `json:"co2Hysteresis"`
+	HygroMin        int `json:"hygroMin"`
+	HygroMax        int `json:"hygroMax"`
+	HygroHysteresis int `json:"hygroHysteresis"`
+	Duration        int `json:"duration"` //in milliseconds
+}
+
+//DispatchStats per device event queues metrics
+type DispatchStats struct {
+	QueueSize  int                         `json:"queueSize"`
+	Overflow   string                      `json:"overflow"`
+	Dispatched int                         `json:"dispatched"`
+	Processed  int                         `json:"processed"`
+	Dropped    int                         `json:"dropped"`
+	Devices    map[string]DeviceQueueStats `json:"devices"`
+}
+
+//DeviceQueueStats event queue metrics of a device
+type DeviceQueueStats struct {
+	Pending int `json:"pending"`
+	Dropped int `json:"dropped"`
+}
+
+//BufferStats offline publish buffer metrics
+type BufferStats struct {
+	Capacity  int `json:"capacity"`
+	Pending   int `json:"pending"`
+	Queued    int `json:"queued"`
+	Coalesced int `json:"coalesced"`
+	Dropped   int `json:"dropped"`
+	Replayed  int `json:"replayed"`
+}
+
+type serviceConfigFile struct {
+	Bridge *BridgeConfig `json:"rest2mqtt"`
+}
+
+//DefaultBridgeConfig configuration used when the section is missing
+func DefaultBridgeConfig() BridgeConfig {
+	buildingProtection := dhvac.OCCUPANCY_BUILDING_PROTECTION
+	return BridgeConfig{
+		Mqtt: MqttConfig{
+			BufferSize: DefaultBufferSize,
+		},
+		Dispatch: DispatchConfig{
+			QueueSize: DefaultQueueSize,
+			Overflow:  OverflowDropOldest,
+		},
+		Publish: PublishConfig{
+			Heartbeat:           DefaultHeartbeat,
+			TemperatureDeadband: DefaultTemperatureDeadband,
+			CO2Deadband:         DefaultCO2Deadband,
+			HygroDeadband:       DefaultHygroDeadband,
+		},
+		Firmware: FirmwareConfig{
+			TftpServer:   DefaultTftpServer,
+			AutoUpdate:   true,
+			Concurrency:  DefaultUpdateConcurrency,
+			PollInterval: DefaultUpdatePollInterval,
+			Timeout:      DefaultUpdateTimeout,
+		},
+		Tftp: TftpConfig{
+			Port: DefaultTftpPort,
+			Root: DefaultTftpRoot,
+		},
+		Modbus: ModbusConfig{
+			Port:        DefaultModbusPort,
+			UnitID:      DefaultModbusUnitID,
+			Timeout:     DefaultModbusTimeout,
+			RegisterMap: DefaultRegisterMap,
+		},
+		Schedules: DefaultSchedules,
+		Timing: TimingConfig{
+			RefreshFrequency: DefaultRefreshFrequency,
+			File:             DefaultTimings,
+		},
+		Groups: GroupConfig{
+			Concurrency: DefaultGroupConcurrency,
+			Timeout:     DefaultGroupTimeout,
+		},
+		Fallback: FallbackConfig{
+			Timeout: DefaultFallbackTimeout,
+		},
+		Injection: InjectionConfig{
+			Heartbeat: DefaultInjectionHeartbeat,
+			MaxAge:    DefaultInjectionMaxAge,
+		},
+		Occupancy: OccupancyConfig{
+			OccupancyDelays: OccupancyDelays{
+				OnDelay:      DefaultOccupancyOnDelay,
+				OffDelay:     DefaultOccupancyOffDelay,
+				EconomyDelay: DefaultOccupancyEconomyDelay,
+			},
+		},
+		Window: WindowConfig{
+			Debounce:        DefaultWindowDebounce,
+			ProtectionDelay: DefaultWindowProtectionDelay,
+			ScheduleAction: ScheduleAction{
+				TargetMode: &buildingProtection,
+			},
+		},
+		Changeover: ChangeoverConfig{
+			HeatBelow:  DefaultChangeoverHeatBelow,
+			CoolAbove:  DefaultChangeoverCoolAbove,
+			Hysteresis: DefaultChangeoverHysteresis,
+			MinDwell:   DefaultChangeoverMinDwell,
+			MaxAge:     DefaultChangeoverMaxAge,
+		},
+		Demand: DemandConfig{
+			Levels: []DemandLevel{
+				{Offset: 10},
+				{Offset: 20},
+				{Offset: 20, Economy: true},
+			},
+		},
+		Condensation: CondensationConfig{
+			Hysteresis: DefaultDewPointHysteresis,
+		},
+		Alarms: AlarmConfig{
+			History:        DefaultAlarmHistory,
+			CO2Max:         DefaultAlarmCO2Max,
+			DriftTolerance: DefaultDriftTolerance,
+			Severities:     DefaultSeverities(),
+		},
+		AirQuality: AirQualityConfig{
+			AirQualityThresholds: AirQualityThresholds{
+				CO2Hysteresis:   DefaultCO2Hysteresis,
+				HygroHysteresis: DefaultHygroHysteresis,
+				Duration:        DefaultAirQualityDuration,
+			},
+		},
+	}
+}
+
+//ReadBridgeConfig parse the rest2mqtt section of the configuration file
+func ReadBridgeConfig(path string) (*BridgeConfig, error) {
+	conf := DefaultBridgeConfig()
+	if path == "" {
+		return &conf, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := serviceConfigFile{
+		Bridge: &conf,
+	}
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Groups.Concurrency <= 0 {
+		conf.Groups.Concurrency = DefaultGroupConcurrency
+	}
+	if conf.Groups.Timeout <= 0 {
+		conf.Groups.Timeout = DefaultGroupTimeout
+	}
+	if conf.Timing.RefreshFrequency <= 0 {
+		conf.Timing.RefreshFrequency = DefaultRefreshFrequency
+	}
+	return &conf, nil
+}
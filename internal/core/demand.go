@@ -0,0 +1,38 @@
+package core
+
+import "time"
+
+//Demand response status of a device
+const (
+	DemandShed     = "shed"
+	DemandRestored = "restored"
+	DemandFailed   = "failed"
+)
+
+//DemandRequest load shedding of the devices of Groups, every configured device when empty
+//Level selects a configured shedding level, 0 cancels the current request. The shedding ends
+//after Duration milliseconds or when cancelled when 0
+type DemandRequest struct {
+	Level    int       `json:"level"`
+	Duration int       `json:"duration"`
+	Groups   []int     `json:"groups"`
+	Until    time.Time `json:"until"`
+}
+
+//DemandDevice shedding of a device
+//Previous holds the values restored at the end of the demand response
+type DemandDevice struct {
+	Mac      string         `json:"mac"`
+	Status   string         `json:"status"`
+	Message  string         `json:"message,omitempty"`
+	Previous ScheduleAction `json:"previous"`
+}
+
+//DemandState current or last demand response
+type DemandState struct {
+	Active    bool           `json:"active"`
+	Request   *DemandRequest `json:"request,omitempty"`
+	StartedAt time.Time      `json:"startedAt"`
+	EndedAt   time.Time      `json:"endedAt"`
+	Devices   []DemandDevice `json:"devices"`
+}
@@ -0,0 +1,14 @@
+package core
+
+import "time"
+
+//FallbackState autonomous mode of a device whose settings stopped
+//Previous holds the values restored when the settings resume
+type FallbackState struct {
+	Mac                  string         `json:"mac"`
+	Active               bool           `json:"active"`
+	LastSetting          time.Time      `json:"lastSetting"`
+	Since                time.Time      `json:"since"`
+	Previous             ScheduleAction `json:"previous"`
+	TemperatureSelection *int           `json:"temperatureSelection,omitempty"` //before the fallback
+}
@@ -0,0 +1,68 @@
+package core
+
+import "time"
+
+//Firmware update status
+const (
+	FirmwareDeferred  = "deferred"
+	FirmwareQueued    = "queued"
+	FirmwareTriggered = "triggered"
+	FirmwareWaiting   = "waiting"
+	FirmwareSuccess   = "success"
+	FirmwareFailed    = "failed"
+	FirmwareTimeout   = "timeout"
+)
+
+//TFTP transfer status
+const (
+	TransferRunning = "running"
+	TransferDone    = "done"
+	TransferFailed  = "failed"
+)
+
+//FirmwareUpdate firmware update progress of a device
+type FirmwareUpdate struct {
+	Mac            string        `json:"mac"`
+	IP             string        `json:"ip"`
+	ProductType    string        `json:"productType"`
+	Legacy         bool          `json:"legacy"`
+	FromVersion    string        `json:"fromVersion"`
+	TargetVersion  string        `json:"targetVersion"`
+	CurrentVersion string        `json:"currentVersion"`
+	Status         string        `json:"status"`
+	Message        string        `json:"message"`
+	Polls          int           `json:"polls"`
+	Transfer       *TftpTransfer `json:"transfer,omitempty"`
+	QueuedAt       time.Time     `json:"queuedAt"`
+	StartedAt      time.Time     `json:"startedAt"`
+	EndedAt        time.Time     `json:"endedAt"`
+}
+
+//Finished return true when the update reached a final status
+func (u FirmwareUpdate) Finished() bool {
+	return u.Status == FirmwareSuccess || u.Status == FirmwareFailed || u.Status == FirmwareTimeout
+}
+
+//FirmwareCampaign list of devices to update
+type FirmwareCampaign struct {
+	Macs []string `json:"macs"`
+}
+
+//TftpTransfer firmware image transfer served by the bridge
+type TftpTransfer struct {
+	ClientIP  string    `json:"clientIP"`
+	File      string    `json:"file"`
+	Size      int64     `json:"size"`
+	Sent      int64     `json:"sent"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+}
+
+//FirmwareRequest manual firmware update of a device
+//Version is optional, the configured target is used otherwise
+type FirmwareRequest struct {
+	Mac     string `json:"mac"`
+	Version string `json:"version"`
+}
@@ -0,0 +1,32 @@
+package core
+
+import "github.com/energieip/common-components-go/pkg/dhvac"
+
+//Group command result of a device
+const (
+	GroupDeviceSuccess = "success"
+	GroupDeviceFailed  = "failed"
+	GroupDeviceTimeout = "timeout"
+)
+
+//GroupCommand setting applied to every configured device of a group, Conf.Mac is ignored
+type GroupCommand struct {
+	Group int
+	Conf  dhvac.HvacConf
+}
+
+//GroupResult aggregated result of a group command
+type GroupResult struct {
+	Group     int                 `json:"group"`
+	Total     int                 `json:"total"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Devices   []GroupDeviceResult `json:"devices"`
+}
+
+//GroupDeviceResult result of a group command on a device
+type GroupDeviceResult struct {
+	Mac     string `json:"mac"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
@@ -1,5 +1,11 @@
 package core
 
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
 //HvacHello network object
 type HvacHello struct {
 	Mac             string `json:"mac"`
@@ -216,3 +222,91 @@ type HvacUpdateParams struct {
 type HvacTask struct {
 	Running bool `json:"running"`
 }
+
+//HvacTiming per device refresh and dump intervals in milliseconds
+type HvacTiming struct {
+	Mac              string `json:"mac"`
+	DumpFrequency    int    `json:"dumpFrequency"`
+	RefreshFrequency int    `json:"refreshFrequency"`
+}
+
+//ReadRefreshFrequencies parse the per device refresh periods file, a missing file means none
+func ReadRefreshFrequencies(path string) (map[string]int, error) {
+	freqs := make(map[string]int)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return freqs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &freqs)
+	if err != nil {
+		return nil, err
+	}
+	return freqs, nil
+}
+
+//WriteRefreshFrequencies save the per device refresh periods file
+func WriteRefreshFrequencies(path string, freqs map[string]int) error {
+	content, err := json.MarshalIndent(freqs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+//HvacValues controller values read during a refresh
+//The json names are the Modbus register map point prefixes
+type HvacValues struct {
+	Loop            HvacLoop1           `json:"loop"`
+	Running         bool                `json:"running"`
+	Setpoints       HvacSetPointsValues `json:"setpoints"`
+	Regulation      HvacSetupRegulation `json:"regulation"`
+	Inputs          HvacInputValues     `json:"inputs"`
+	Outputs         HvacOutputValues    `json:"outputs"`
+	Maintenance     HvacOutputValues    `json:"maintenance"`
+	SoftwareVersion string              `json:"softwareVersion"`
+}
+
+//MaxLoops number of regulation loops of a controller
+const MaxLoops = 2
+
+//HvacLoopStatus measures and setpoints of a regulation loop
+//Temperatures are in tenth of °C like the dhvac status
+type HvacLoopStatus struct {
+	Mac                    string `json:"mac"`
+	Loop                   int    `json:"loop"`
+	SpaceTemp              int    `json:"spaceTemp"`
+	EffectSetPoint         int    `json:"effectSetPoint"`
+	OccManCmd              int    `json:"occManCmd"`
+	HeatCool               int    `json:"heatCool"`
+	HeatOutput             int    `json:"heatOutput"`
+	CoolOutput             int    `json:"coolOutput"`
+	HoldOff                int    `json:"holdOff"`
+	DewSensor              int    `json:"dewSensor"`
+	SetpointOccupiedCool   int    `json:"setpointOccupiedCool"`
+	SetpointOccupiedHeat   int    `json:"setpointOccupiedHeat"`
+	SetpointUnoccupiedCool int    `json:"setpointUnoccupiedCool"`
+	SetpointUnoccupiedHeat int    `json:"setpointUnoccupiedHeat"`
+	SetpointStandbyCool    int    `json:"setpointStandbyCool"`
+	SetpointStandbyHeat    int    `json:"setpointStandbyHeat"`
+}
+
+//HvacLoopConf command targeting a regulation loop, missing values are left unchanged
+//Temperatures are in tenth of °C like the dhvac configuration
+type HvacLoopConf struct {
+	Mac                    string `json:"mac"`
+	Loop                   int    `json:"loop"`
+	WindowStatus           *bool  `json:"windowStatus,omitempty"`
+	Temperature            *int   `json:"temperature,omitempty"`
+	Presence               *bool  `json:"presence,omitempty"`
+	TargetMode             *int   `json:"targetMode,omitempty"`
+	HeatCool               *int   `json:"heatCool,omitempty"`
+	SetpointCoolOccupied   *int   `json:"setpointCoolOccupied,omitempty"`
+	SetpointHeatOccupied   *int   `json:"setpointHeatOccupied,omitempty"`
+	SetpointCoolInoccupied *int   `json:"setpointCoolInoccupied,omitempty"`
+	SetpointHeatInoccupied *int   `json:"setpointHeatInoccupied,omitempty"`
+	SetpointCoolStandby    *int   `json:"setpointCoolStandby,omitempty"`
+	SetpointHeatStandby    *int   `json:"setpointHeatStandby,omitempty"`
+}
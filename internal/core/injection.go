@@ -0,0 +1,24 @@
+package core
+
+import "time"
+
+//Injected measures
+const (
+	MeasureTemperature = "temperature"
+	MeasureCO2         = "co2"
+	MeasureHygrometry  = "hygrometry"
+)
+
+//InjectedMeasure measure fed by the server, Value is in the dhvac unit
+type InjectedMeasure struct {
+	Value      int       `json:"value"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	SentAt     time.Time `json:"sentAt"`
+	Stale      bool      `json:"stale"`
+}
+
+//InjectionState measures injected in a device by name
+type InjectionState struct {
+	Mac      string                     `json:"mac"`
+	Measures map[string]InjectedMeasure `json:"measures"`
+}
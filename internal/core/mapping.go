@@ -0,0 +1,53 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+//Field mapping directions
+const (
+	MappingRead  = "read"
+	MappingWrite = "write"
+)
+
+//FieldMapping conversion between a dhvac field and a controller value
+//Field is the Go name of the dhvac.Hvac (read) or dhvac.HvacConf (write) field
+//Path is the json path of the controller value: the first element selects the endpoint
+//("loop", "running", "setpoints", "regulation", "inputs", "outputs", "maintenance", "softwareVersion"
+//in HvacValues for reads, "loop" or "setpoints" in HvacCommand for writes)
+//
+//Read: Field = Path * Multiply * Scale + Offset + Plus, or Field = Offset when Match is set and Path equals Match
+//Write: Path = (Field * Scale + Offset) / Divide, integers are truncated toward zero
+//A zero Scale means 1, Multiply is a value path, Plus and Divide are dhvac.Hvac fields of the device
+type FieldMapping struct {
+	Field     string   `json:"field"`
+	Direction string   `json:"direction"`
+	Path      string   `json:"path"`
+	Scale     float64  `json:"scale"`
+	Offset    float64  `json:"offset"`
+	Multiply  string   `json:"multiply,omitempty"`
+	Plus      string   `json:"plus,omitempty"`
+	Divide    string   `json:"divide,omitempty"`
+	Match     *float64 `json:"match,omitempty"`
+}
+
+//HvacCommand controller values written from a dhvac configuration
+type HvacCommand struct {
+	Loop      HvacLoopCtrl  `json:"loop"`
+	Setpoints HvacSetPoints `json:"setpoints"`
+}
+
+//ReadFieldMapping parse a field mapping file
+func ReadFieldMapping(path string) ([]FieldMapping, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fields []FieldMapping
+	err = json.Unmarshal(file, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+const (
+	ProtocolREST   = "REST"
+	ProtocolModbus = "MODBUS"
+
+	RegisterHolding = "holding"
+	RegisterInput   = "input"
+)
+
+//ModbusRegister location and encoding of a point
+//The point value is the raw register value multiplied by Scale
+type ModbusRegister struct {
+	Address uint16  `json:"address"`
+	Type    string  `json:"type"` //holding or input
+	Scale   float64 `json:"scale"`
+	Signed  bool    `json:"signed"`
+}
+
+//RegisterMap Modbus layout of a controller
+//Points are named after the json path of the values, e.g. "loop.regulation.spaceTemp",
+//"setpoints.setpointOccCool", "inputs.inputE1" or "running"
+type RegisterMap struct {
+	Probe  ModbusRegister            `json:"probe"`
+	Points map[string]ModbusRegister `json:"points"`
+}
+
+//ReadRegisterMap parse a register map file
+func ReadRegisterMap(path string) (*RegisterMap, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	registers := RegisterMap{}
+	err = json.Unmarshal(file, &registers)
+	if err != nil {
+		return nil, err
+	}
+	return &registers, nil
+}
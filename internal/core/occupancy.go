@@ -0,0 +1,49 @@
+package core
+
+import "time"
+
+//Occupancy states computed from the presence
+const (
+	OccupancyUnmanaged = "unmanaged" //mode given by the server
+	OccupancyArriving  = "arriving"  //presence shorter than the on delay
+	OccupancyOccupied  = "occupied"
+	OccupancyLeaving   = "leaving" //absence shorter than the off delay
+	OccupancyStandby   = "standby"
+	OccupancyEconomy   = "economy"
+	OccupancyForced    = "override"
+)
+
+//OccupancyDelays presence filtering of a device, in milliseconds
+//EconomyDelay 0 disables the economy mode
+type OccupancyDelays struct {
+	OnDelay      int `json:"onDelay"`
+	OffDelay     int `json:"offDelay"`
+	EconomyDelay int `json:"economyDelay"`
+}
+
+//OccupancyDelaysRequest delays of a device
+type OccupancyDelaysRequest struct {
+	Mac string `json:"mac"`
+	OccupancyDelays
+}
+
+//OccupancyOverride mode forced whatever the presence, for Duration milliseconds or until cleared when 0
+type OccupancyOverride struct {
+	Mac      string    `json:"mac"`
+	Mode     int       `json:"mode"`
+	Duration int       `json:"duration"`
+	Until    time.Time `json:"until"`
+}
+
+//OccupancyState occupancy state machine of a device
+type OccupancyState struct {
+	Mac           string             `json:"mac"`
+	State         string             `json:"state"`
+	Mode          int                `json:"mode"` //occupancy mode sent to the controller
+	Presence      bool               `json:"presence"`
+	PresenceSince time.Time          `json:"presenceSince"`
+	Managed       bool               `json:"managed"`
+	Delays        OccupancyDelays    `json:"delays"`
+	Override      *OccupancyOverride `json:"override,omitempty"`
+	ChangedAt     time.Time          `json:"changedAt"`
+}
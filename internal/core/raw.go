@@ -0,0 +1,19 @@
+package core
+
+//RawRequest call of the controller REST API forwarded as is
+//Path is relative to /api/ on the controller
+type RawRequest struct {
+	Mac         string
+	Method      string
+	Path        string
+	Query       string
+	ContentType string
+	Body        []byte
+}
+
+//RawResponse controller answer to a raw request
+type RawResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
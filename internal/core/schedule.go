@@ -0,0 +1,94 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+//Schedule entries
+const (
+	ScheduleDefault   = "default"
+	SchedulePeriod    = "period"
+	ScheduleException = "exception"
+)
+
+//Schedule weekly occupancy program executed by the bridge
+//It applies to the listed devices and to the devices of the listed groups
+//When several schedules match a device, the highest Priority wins
+type Schedule struct {
+	ID         string                  `json:"id"`
+	Macs       []string                `json:"macs"`
+	Groups     []int                   `json:"groups"`
+	Priority   int                     `json:"priority"`
+	Default    *ScheduleAction         `json:"default,omitempty"` //outside the periods
+	Periods    []ScheduleWeeklyPeriod  `json:"periods"`
+	Exceptions []ScheduleExceptionDays `json:"exceptions"`
+}
+
+//ScheduleAction occupancy mode and setpoints (tenth of °C) applied when an entry starts
+type ScheduleAction struct {
+	TargetMode             *int `json:"targetMode,omitempty"`
+	SetpointCoolOccupied   *int `json:"setpointCoolOccupied,omitempty"`
+	SetpointHeatOccupied   *int `json:"setpointHeatOccupied,omitempty"`
+	SetpointCoolInoccupied *int `json:"setpointCoolInoccupied,omitempty"`
+	SetpointHeatInoccupied *int `json:"setpointHeatInoccupied,omitempty"`
+	SetpointCoolStandby    *int `json:"setpointCoolStandby,omitempty"`
+	SetpointHeatStandby    *int `json:"setpointHeatStandby,omitempty"`
+}
+
+//ScheduleWeeklyPeriod local time period of the week
+//Days are 0 (sunday) to 6, Start and End are "15:04", the period ends after midnight when End is before Start
+type ScheduleWeeklyPeriod struct {
+	Days  []int  `json:"days"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	ScheduleAction
+}
+
+//ScheduleExceptionDays days, e.g. holidays, replacing the weekly periods
+//From and To are "2006-01-02" local dates, both included
+type ScheduleExceptionDays struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	ScheduleAction
+}
+
+//ActiveSchedule schedule entry applied to a device
+type ActiveSchedule struct {
+	Mac        string         `json:"mac"`
+	ScheduleID string         `json:"scheduleID"`
+	Entry      string         `json:"entry"` //default, period or exception
+	Index      int            `json:"index"` //of the period or exception
+	Name       string         `json:"name"`
+	Action     ScheduleAction `json:"action"`
+	AppliedAt  time.Time      `json:"appliedAt"`
+}
+
+//ReadSchedules parse the schedules file, a missing file means no schedule
+func ReadSchedules(path string) ([]Schedule, error) {
+	schedules := []Schedule{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return schedules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &schedules)
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+//WriteSchedules save the schedules file
+func WriteSchedules(path string, schedules []Schedule) error {
+	content, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,20 @@
+package core
+
+import "time"
+
+//WindowState window contact interlock of a device
+//Raw is the last received contact, Open the debounced one
+//Previous holds the values restored when the window closes after the protection
+//A device which could not be written is tried again from RetryAt, the delay doubles with Failures
+type WindowState struct {
+	Mac       string         `json:"mac"`
+	Open      bool           `json:"open"`
+	Raw       bool           `json:"raw"`
+	RawSince  time.Time      `json:"rawSince"`
+	OpenSince time.Time      `json:"openSince"`
+	Protected bool           `json:"protected"`
+	Previous  ScheduleAction `json:"previous"`
+	ChangedAt time.Time      `json:"changedAt"`
+	Failures  int            `json:"failures"`
+	RetryAt   time.Time      `json:"retryAt"`
+}
@@ -0,0 +1,153 @@
+package drivers
+
+import (
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	pkg "github.com/energieip/common-components-go/pkg/service"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+//DefaultProduct product type of the driver used when no dedicated one is registered
+const DefaultProduct = ""
+
+//Driver operations of a controller family
+//Values exchanged with the service are the dhvac ones, each driver converting them to its own API
+type Driver interface {
+	//Protocol return the protocol published in the device hello
+	Protocol() string
+	//Probe return the system information when the controller answers with this driver
+	Probe(IP string) (*core.HvacSysInfo, error)
+	//Login return the token given to the other operations
+	Login(IP string) (string, error)
+	//ReadStatus refresh the measures and the configuration of the status
+	ReadStatus(status *dhvac.Hvac, token string) error
+	//ApplySetup send the initial setup
+	ApplySetup(setup dhvac.HvacSetup, IP string, token string) error
+	//ApplyRegulation send the regulation part of a setup only, TemperatureOffsetStep is mandatory
+	ApplyRegulation(setup dhvac.HvacSetup, IP string, token string) error
+	//ReadAirRegister return the air register setup, unknown values are left unset
+	ReadAirRegister(IP string, token string) (*core.HvacSetupAirQualityCtrl, error)
+	//ApplyAirRegister send the set values of the air register setup only
+	ApplyAirRegister(mac string, config core.HvacSetupAirQualityCtrl, IP string, token string) error
+	//ApplyConf send a runtime configuration
+	ApplyConf(conf dhvac.HvacConf, status dhvac.Hvac, token string) error
+	//ReadLoops return the regulation loops used after the first one
+	ReadLoops(status dhvac.Hvac, token string) ([]core.HvacLoopStatus, error)
+	//ApplyLoopConf send a command to a regulation loop
+	ApplyLoopConf(conf core.HvacLoopConf, status dhvac.Hvac, token string) error
+	//Reboot restart the controller
+	Reboot(IP string, token string) error
+	//Update start a firmware update from the TFTP server
+	Update(update core.FirmwareUpdate, tftpServer string) error
+	//Raw forward a request to the controller API and return its answer whatever the status code
+	Raw(IP string, token string, request core.RawRequest) (*core.RawResponse, error)
+}
+
+//Config configuration given to the driver factories
+//Mapping is filled by NewRegistry
+type Config struct {
+	Service pkg.ServiceConfig
+	Bridge  core.BridgeConfig
+	Mapping *Mapping
+}
+
+//Factory create a driver, a nil driver disables it
+type Factory func(conf Config) (Driver, error)
+
+type registration struct {
+	productType string
+	factory     Factory
+}
+
+var factories []registration
+
+//Register add a driver for a product type reported by the controller system information
+//Drivers are registered from their init function and probed in registration order after the default one
+func Register(productType string, factory Factory) {
+	factories = append(factories, registration{
+		productType: productType,
+		factory:     factory,
+	})
+}
+
+//Registry drivers instantiated from the configuration
+type Registry struct {
+	drivers  map[string]Driver
+	probing  []Driver
+	fallback Driver
+}
+
+type driverError struct {
+	s string
+}
+
+func (e *driverError) Error() string {
+	return e.s
+}
+
+// NewError raise an error
+func NewError(text string) error {
+	return &driverError{text}
+}
+
+//NewRegistry create the registered drivers
+func NewRegistry(conf Config) (*Registry, error) {
+	mapping, err := NewMapping(conf.Bridge.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	conf.Mapping = mapping
+	r := &Registry{
+		drivers: make(map[string]Driver),
+	}
+	for _, reg := range factories {
+		driver, err := reg.factory(conf)
+		if err != nil {
+			return nil, err
+		}
+		if driver == nil {
+			continue
+		}
+		r.drivers[reg.productType] = driver
+		if reg.productType == DefaultProduct {
+			r.fallback = driver
+			continue
+		}
+		r.probing = append(r.probing, driver)
+	}
+	if r.fallback == nil {
+		return nil, NewError("No default driver registered")
+	}
+	// the default driver is always probed first
+	r.probing = append([]Driver{r.fallback}, r.probing...)
+	return r, nil
+}
+
+//Default return the driver used for unknown controllers
+func (r *Registry) Default() Driver {
+	return r.fallback
+}
+
+//Get return the driver of a product type
+func (r *Registry) Get(productType string) (Driver, bool) {
+	driver, ok := r.drivers[productType]
+	return driver, ok
+}
+
+//Probe find the driver of a controller
+//The first driver answering gives the product type, its dedicated driver is preferred when registered
+func (r *Registry) Probe(IP string) (Driver, *core.HvacSysInfo, error) {
+	err := NewError("No driver answers on " + IP)
+	for _, driver := range r.probing {
+		info, errProbe := driver.Probe(IP)
+		if errProbe != nil {
+			err = errProbe
+			continue
+		}
+		dedicated, ok := r.Get(info.ProductType)
+		if ok && dedicated != driver {
+			return dedicated, info, nil
+		}
+		return driver, info, nil
+	}
+	return nil, nil, err
+}
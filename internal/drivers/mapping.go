@@ -0,0 +1,270 @@
+package drivers
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/jsonpath"
+	"github.com/romana/rlog"
+)
+
+var testMode = float64(0)
+
+//defaultMapping conversions of the REST firmware, temperatures and hygrometry are in tenth in dhvac
+var defaultMapping = []core.FieldMapping{
+	{Field: "LinePower", Direction: core.MappingRead, Offset: 10},
+	{Field: "SpaceCO2", Direction: core.MappingRead, Path: "loop.airRegister.spaceCO2"},
+	{Field: "OADamper", Direction: core.MappingRead, Path: "loop.airRegister.OADamper"},
+	{Field: "SpaceHygro", Direction: core.MappingRead, Path: "loop.airRegister.spaceHygroRel", Scale: 10},
+	{Field: "OccManCmd1", Direction: core.MappingRead, Path: "loop.regulation.occManCmd"},
+	{Field: "DewSensor1", Direction: core.MappingRead, Path: "loop.regulation.dewSensor"},
+	{Field: "SpaceTemp1", Direction: core.MappingRead, Path: "loop.regulation.spaceTemp", Scale: 10},
+	{Field: "HeatCool1", Direction: core.MappingRead, Path: "loop.regulation.heatCool"},
+	{Field: "HeatCool1", Direction: core.MappingRead, Path: "running", Match: &testMode, Offset: dhvac.HVAC_MODE_TEST},
+	{Field: "CoolOutput1", Direction: core.MappingRead, Path: "loop.regulation.coolOutput"},
+	{Field: "HeatOutput1", Direction: core.MappingRead, Path: "loop.regulation.heatOutput"},
+	{Field: "EffectSetPoint1", Direction: core.MappingRead, Path: "loop.regulation.effectifSetPoint", Scale: 10},
+	{Field: "HoldOff1", Direction: core.MappingRead, Path: "loop.regulation.windowHoldOff"},
+	{Field: "SetpointUnoccupiedHeat1", Direction: core.MappingRead, Path: "setpoints.setpointUnoccHeat", Scale: 10},
+	{Field: "SetpointUnoccupiedCool1", Direction: core.MappingRead, Path: "setpoints.setpointUnoccCool", Scale: 10},
+	{Field: "SetpointOccupiedCool1", Direction: core.MappingRead, Path: "setpoints.setpointOccCool", Scale: 10},
+	{Field: "SetpointOccupiedHeat1", Direction: core.MappingRead, Path: "setpoints.setpointOccHeat", Scale: 10},
+	{Field: "SetpointStandbyCool1", Direction: core.MappingRead, Path: "setpoints.setpointStanbyCool", Scale: 10},
+	{Field: "SetpointStandbyHeat1", Direction: core.MappingRead, Path: "setpoints.setpointStanbyHeat", Scale: 10},
+	{Field: "TemperatureOffsetStep", Direction: core.MappingRead, Path: "regulation.temperOffsetStep", Scale: 10},
+	{Field: "InputE1", Direction: core.MappingRead, Path: "inputs.inputE1"},
+	{Field: "InputE2", Direction: core.MappingRead, Path: "inputs.inputE2"},
+	{Field: "InputE3", Direction: core.MappingRead, Path: "inputs.inputE3"},
+	{Field: "InputE4", Direction: core.MappingRead, Path: "inputs.inputE4"},
+	{Field: "InputE5", Direction: core.MappingRead, Path: "inputs.inputE5"},
+	{Field: "InputE6", Direction: core.MappingRead, Path: "inputs.inputE6"},
+	{Field: "InputC1", Direction: core.MappingRead, Path: "inputs.inputC1"},
+	{Field: "InputC2", Direction: core.MappingRead, Path: "inputs.inputC2"},
+	{Field: "OutputY5", Direction: core.MappingRead, Path: "outputs.outputY5"},
+	{Field: "OutputY6", Direction: core.MappingRead, Path: "outputs.outputY6"},
+	{Field: "OutputY7", Direction: core.MappingRead, Path: "outputs.outputY7"},
+	{Field: "OutputY8", Direction: core.MappingRead, Path: "outputs.outputY8"},
+	{Field: "OutputYa", Direction: core.MappingRead, Path: "outputs.outputYa"},
+	{Field: "OutputYb", Direction: core.MappingRead, Path: "outputs.outputYb"},
+	{Field: "SoftwareVersion", Direction: core.MappingRead, Path: "softwareVersion"},
+	{Field: "Forcing6WaysValve", Direction: core.MappingRead, Path: "maintenance.outputY5"},
+	{Field: "ForcingDamper", Direction: core.MappingRead, Path: "maintenance.outputY6"},
+	{Field: "Shift", Direction: core.MappingRead, Path: "loop.regulation.offsetTemp", Multiply: "regulation.temperOffsetStep", Scale: 10},
+	{Field: "TemperatureSelect", Direction: core.MappingRead, Path: "loop.regulation.effectifSetPoint", Scale: 10, Plus: "Shift"},
+
+	{Field: "WindowStatus", Direction: core.MappingWrite, Path: "loop.regulation.windowHoldOff"},
+	{Field: "Temperature", Direction: core.MappingWrite, Path: "loop.regulation.spaceTemp", Scale: 0.1},
+	{Field: "CO2", Direction: core.MappingWrite, Path: "loop.airRegister.spaceCO2", Scale: 0.1},
+	{Field: "Hygrometry", Direction: core.MappingWrite, Path: "loop.airRegister.spaceHygroRel", Scale: 0.1},
+	{Field: "Shift", Direction: core.MappingWrite, Path: "loop.regulation.offsetTemp", Divide: "TemperatureOffsetStep"},
+	{Field: "TargetMode", Direction: core.MappingWrite, Path: "loop.regulation.occManCmd"},
+	{Field: "SetpointCoolOccupied", Direction: core.MappingWrite, Path: "setpoints.setpointOccCool", Scale: 0.1},
+	{Field: "SetpointHeatOccupied", Direction: core.MappingWrite, Path: "setpoints.setpointOccHeat", Scale: 0.1},
+	{Field: "SetpointHeatInoccupied", Direction: core.MappingWrite, Path: "setpoints.setpointUnoccHeat", Scale: 0.1},
+	{Field: "SetpointCoolInoccupied", Direction: core.MappingWrite, Path: "setpoints.setpointUnoccCool", Scale: 0.1},
+	{Field: "SetpointCoolStandby", Direction: core.MappingWrite, Path: "setpoints.setpointStanbyCool", Scale: 0.1},
+	{Field: "SetpointHeatStandby", Direction: core.MappingWrite, Path: "setpoints.setpointStanbyHeat", Scale: 0.1},
+}
+
+//Mapping field conversions between the dhvac objects and the controller values
+type Mapping struct {
+	fields []core.FieldMapping
+}
+
+//NewMapping return the default mapping, the entries of the file replace the default ones of the same field and direction
+func NewMapping(path string) (*Mapping, error) {
+	fields := defaultMapping
+	if path != "" {
+		overrides, err := core.ReadFieldMapping(path)
+		if err != nil {
+			rlog.Error("Cannot parse field mapping " + err.Error())
+			return nil, err
+		}
+		fields = mergeMapping(defaultMapping, overrides)
+	}
+	for _, field := range fields {
+		err := checkMapping(field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Mapping{
+		fields: fields,
+	}, nil
+}
+
+func mappingKey(field core.FieldMapping) string {
+	return field.Direction + "/" + field.Field
+}
+
+//mergeMapping replace the entries in place to keep the evaluation order, Plus refers to a previous entry
+func mergeMapping(fields []core.FieldMapping, overrides []core.FieldMapping) []core.FieldMapping {
+	replaced := make(map[string][]core.FieldMapping)
+	keys := []string{}
+	for _, field := range overrides {
+		key := mappingKey(field)
+		if _, ok := replaced[key]; !ok {
+			keys = append(keys, key)
+		}
+		replaced[key] = append(replaced[key], field)
+	}
+	merged := []core.FieldMapping{}
+	for _, field := range fields {
+		key := mappingKey(field)
+		entries, ok := replaced[key]
+		if !ok {
+			merged = append(merged, field)
+			continue
+		}
+		merged = append(merged, entries...)
+		replaced[key] = nil
+	}
+	for _, key := range keys {
+		merged = append(merged, replaced[key]...)
+	}
+	return merged
+}
+
+//checkMapping reject the entries whose field or path does not exist
+func checkMapping(field core.FieldMapping) error {
+	switch field.Direction {
+	case core.MappingRead:
+		if _, ok := reflect.TypeOf(dhvac.Hvac{}).FieldByName(field.Field); !ok {
+			return NewError("Unknown dhvac status field " + field.Field)
+		}
+		if field.Plus != "" {
+			if _, ok := reflect.TypeOf(dhvac.Hvac{}).FieldByName(field.Plus); !ok {
+				return NewError("Unknown dhvac status field " + field.Plus)
+			}
+		}
+		if field.Multiply != "" {
+			if _, ok := jsonpath.Get(core.HvacValues{}, field.Multiply); !ok {
+				return NewError("Unknown value " + field.Multiply + " for " + field.Field)
+			}
+		}
+		if field.Path == "" {
+			return nil
+		}
+		if _, ok := jsonpath.Get(core.HvacValues{}, field.Path); !ok {
+			return NewError("Unknown value " + field.Path + " for " + field.Field)
+		}
+	case core.MappingWrite:
+		if _, ok := reflect.TypeOf(dhvac.HvacConf{}).FieldByName(field.Field); !ok {
+			return NewError("Unknown dhvac configuration field " + field.Field)
+		}
+		if field.Divide != "" {
+			if _, ok := reflect.TypeOf(dhvac.Hvac{}).FieldByName(field.Divide); !ok {
+				return NewError("Unknown dhvac status field " + field.Divide)
+			}
+		}
+		if !jsonpath.Set(&core.HvacCommand{}, field.Path, 0) {
+			return NewError("Unknown command " + field.Path + " for " + field.Field)
+		}
+	default:
+		return NewError("Invalid mapping direction " + field.Direction + " for " + field.Field)
+	}
+	return nil
+}
+
+func fieldScale(field core.FieldMapping) float64 {
+	if field.Scale == 0 {
+		return 1
+	}
+	return field.Scale
+}
+
+//precision round value to a float32 like the firmware conversions when the controller value is a float32
+func precision(value float64, single bool) float64 {
+	if single {
+		return float64(float32(value))
+	}
+	return value
+}
+
+//FillStatus convert the controller values into the published status
+//The value is (Path * Multiply * Scale + Offset) truncated, then Plus is added
+func (m *Mapping) FillStatus(status *dhvac.Hvac, values core.HvacValues) {
+	target := reflect.ValueOf(status).Elem()
+	for _, field := range m.fields {
+		if field.Direction != core.MappingRead {
+			continue
+		}
+		dest := target.FieldByName(field.Field)
+		value := float64(0)
+		single := false
+		if field.Path != "" {
+			source, ok := jsonpath.Get(values, field.Path)
+			if !ok {
+				continue
+			}
+			if source.Kind() == reflect.String {
+				if source.String() != "" && dest.Kind() == reflect.String {
+					dest.SetString(source.String())
+				}
+				continue
+			}
+			value, ok = jsonpath.Number(source)
+			if !ok {
+				continue
+			}
+			single = source.Kind() == reflect.Float32
+		}
+		if field.Match != nil {
+			if value == *field.Match {
+				jsonpath.SetNumber(dest, field.Offset)
+			}
+			continue
+		}
+		if field.Multiply != "" {
+			factor, ok := jsonpath.Get(values, field.Multiply)
+			if !ok {
+				continue
+			}
+			n, _ := jsonpath.Number(factor)
+			single = single || factor.Kind() == reflect.Float32
+			value = precision(value*n, single)
+		}
+		value = precision(value*fieldScale(field), single) + field.Offset
+		if field.Plus != "" {
+			n, _ := jsonpath.Number(target.FieldByName(field.Plus))
+			value = math.Trunc(value) + n
+		}
+		jsonpath.SetNumber(dest, value)
+	}
+	status.Error = 0
+}
+
+//command convert the configuration values which are set
+func (m *Mapping) command(conf dhvac.HvacConf, status dhvac.Hvac) core.HvacCommand {
+	cmd := core.HvacCommand{}
+	source := reflect.ValueOf(conf)
+	device := reflect.ValueOf(status)
+	for _, field := range m.fields {
+		if field.Direction != core.MappingWrite {
+			continue
+		}
+		v := source.FieldByName(field.Field)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		value, ok := jsonpath.Number(v)
+		if !ok {
+			continue
+		}
+		value = value*fieldScale(field) + field.Offset
+		if field.Divide != "" {
+			divisor, _ := jsonpath.Number(device.FieldByName(field.Divide))
+			if divisor == 0 {
+				continue
+			}
+			value /= divisor
+		}
+		jsonpath.Set(&cmd, field.Path, value)
+	}
+	return cmd
+}
@@ -0,0 +1,391 @@
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+func testValues() core.HvacValues {
+	values := core.HvacValues{Running: true, SoftwareVersion: "1.2.3"}
+	values.Loop.AirRegister = core.HvacAirRegister{SpaceCO2: 650, OADamper: 40, SpaceHygroRel: 45}
+	values.Loop.Regulation = core.HvacRegulation{
+		WindowHoldOff:    1,
+		SpaceTemp:        21.3,
+		OffsetTemp:       3,
+		OccManCmd:        dhvac.OCCUPANCY_COMFORT,
+		HeatCool:         dhvac.HVAC_MODE_HEAT,
+		EffectifSetPoint: 19.7,
+		HeatOutput:       55,
+		CoolOutput:       0,
+		DewSensor:        1,
+	}
+	values.Setpoints = core.HvacSetPointsValues{
+		SetpointOccCool:    24.1,
+		SetpointOccHeat:    20.3,
+		SetpointUnoccCool:  28.7,
+		SetpointUnoccHeat:  16.9,
+		SetpointStanbyCool: 26.35,
+		SetpointStanbyHeat: 18.15,
+	}
+	values.Regulation.TemperOffsetStep = 0.3
+	values.Inputs = core.HvacInputValues{InputE1: 1, InputE2: 2, InputE3: 3, InputE4: 4, InputE5: 5, InputE6: 6, InputC1: 7, InputC2: 8}
+	values.Outputs = core.HvacOutputValues{OutputY5: 15, OutputY6: 16, OutputY7: 17, OutputY8: 18, OutputYa: 19, OutputYb: 20}
+	values.Maintenance = core.HvacOutputValues{OutputY5: 25, OutputY6: 26}
+	return values
+}
+
+//TestFillStatus compare the default read entries with the conversions of the REST driver before the mapping
+func TestFillStatus(t *testing.T) {
+	mapping, err := NewMapping("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := testValues()
+	regul := values.Loop.Regulation
+	setpoints := values.Setpoints
+	step := values.Regulation.TemperOffsetStep
+	shift := int((float32(regul.OffsetTemp) * step) * 10)
+
+	status := dhvac.Hvac{Error: 1}
+	mapping.FillStatus(&status, values)
+	tests := []struct {
+		field    string
+		expected interface{}
+	}{
+		{field: "LinePower", expected: 10},
+		{field: "SpaceCO2", expected: 650},
+		{field: "OADamper", expected: 40},
+		{field: "SpaceHygro", expected: values.Loop.AirRegister.SpaceHygroRel * 10},
+		{field: "OccManCmd1", expected: dhvac.OCCUPANCY_COMFORT},
+		{field: "DewSensor1", expected: 1},
+		{field: "SpaceTemp1", expected: int(regul.SpaceTemp * 10)},
+		{field: "HeatCool1", expected: dhvac.HVAC_MODE_HEAT},
+		{field: "CoolOutput1", expected: 0},
+		{field: "HeatOutput1", expected: 55},
+		{field: "EffectSetPoint1", expected: int(regul.EffectifSetPoint * 10)},
+		{field: "HoldOff1", expected: 1},
+		{field: "SetpointUnoccupiedHeat1", expected: int(setpoints.SetpointUnoccHeat * 10)},
+		{field: "SetpointUnoccupiedCool1", expected: int(setpoints.SetpointUnoccCool * 10)},
+		{field: "SetpointOccupiedCool1", expected: int(setpoints.SetpointOccCool * 10)},
+		{field: "SetpointOccupiedHeat1", expected: int(setpoints.SetpointOccHeat * 10)},
+		{field: "SetpointStandbyCool1", expected: int(setpoints.SetpointStanbyCool * 10)},
+		{field: "SetpointStandbyHeat1", expected: int(setpoints.SetpointStanbyHeat * 10)},
+		{field: "TemperatureOffsetStep", expected: int(step * 10)},
+		{field: "InputE1", expected: 1},
+		{field: "InputE2", expected: 2},
+		{field: "InputE3", expected: 3},
+		{field: "InputE4", expected: 4},
+		{field: "InputE5", expected: 5},
+		{field: "InputE6", expected: 6},
+		{field: "InputC1", expected: 7},
+		{field: "InputC2", expected: 8},
+		{field: "OutputY5", expected: 15},
+		{field: "OutputY6", expected: 16},
+		{field: "OutputY7", expected: 17},
+		{field: "OutputY8", expected: 18},
+		{field: "OutputYa", expected: 19},
+		{field: "OutputYb", expected: 20},
+		{field: "SoftwareVersion", expected: "1.2.3"},
+		{field: "Forcing6WaysValve", expected: 25},
+		{field: "ForcingDamper", expected: 26},
+		{field: "Shift", expected: shift},
+		{field: "TemperatureSelect", expected: int(regul.EffectifSetPoint*10) + shift},
+		{field: "Error", expected: 0},
+	}
+	result := reflect.ValueOf(status)
+	for _, test := range tests {
+		value := result.FieldByName(test.field).Interface()
+		if value != test.expected {
+			t.Errorf("%v: got %v, expected %v", test.field, value, test.expected)
+		}
+	}
+}
+
+func TestFillStatusConversions(t *testing.T) {
+	mapping, err := NewMapping("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		values func(values *core.HvacValues)
+		check  func(status dhvac.Hvac) bool
+	}{
+		{
+			name:   "test mode when not running",
+			values: func(values *core.HvacValues) { values.Running = false },
+			check:  func(status dhvac.Hvac) bool { return status.HeatCool1 == dhvac.HVAC_MODE_TEST },
+		},
+		{
+			name:   "truncated temperature",
+			values: func(values *core.HvacValues) { values.Loop.Regulation.SpaceTemp = 21.35 },
+			check:  func(status dhvac.Hvac) bool { return status.SpaceTemp1 == 213 },
+		},
+		{
+			name:   "negative shift",
+			values: func(values *core.HvacValues) { values.Loop.Regulation.OffsetTemp = -2 },
+			check: func(status dhvac.Hvac) bool {
+				shift := int((float32(-2) * float32(0.3)) * 10)
+				return status.Shift == shift && status.TemperatureSelect == int(float32(19.7)*10)+shift
+			},
+		},
+		{
+			name: "setpoint truncated before the shift",
+			values: func(values *core.HvacValues) {
+				values.Loop.Regulation.EffectifSetPoint = 0.25
+				values.Loop.Regulation.OffsetTemp = -1
+				values.Regulation.TemperOffsetStep = 0.5
+			},
+			check: func(status dhvac.Hvac) bool { return status.Shift == -5 && status.TemperatureSelect == -3 },
+		},
+	}
+	for _, test := range tests {
+		values := testValues()
+		test.values(&values)
+		status := dhvac.Hvac{}
+		mapping.FillStatus(&status, values)
+		if !test.check(status) {
+			t.Errorf("%v: got %+v", test.name, status)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	mapping, err := NewMapping("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := true
+	temperature := 215
+	co2 := 455
+	hygro := 459
+	shift := 15
+	negative := -15
+	mode := dhvac.OCCUPANCY_ECONOMY
+	setpoint := 243
+	status := dhvac.Hvac{TemperatureOffsetStep: 10}
+
+	tests := []struct {
+		name     string
+		conf     dhvac.HvacConf
+		status   dhvac.Hvac
+		expected map[string]interface{}
+	}{
+		{
+			name:     "window",
+			conf:     dhvac.HvacConf{WindowStatus: &open},
+			status:   status,
+			expected: map[string]interface{}{"WindowHoldOff": 1},
+		},
+		{
+			name:     "temperature",
+			conf:     dhvac.HvacConf{Temperature: &temperature},
+			status:   status,
+			expected: map[string]interface{}{"SpaceTemp": float32(temperature) / 10.0},
+		},
+		{
+			name:     "co2 and hygrometry truncated",
+			conf:     dhvac.HvacConf{CO2: &co2, Hygrometry: &hygro},
+			status:   status,
+			expected: map[string]interface{}{"SpaceCO2": co2 / 10, "SpaceHygroRel": hygro / 10},
+		},
+		{
+			name:     "shift truncated",
+			conf:     dhvac.HvacConf{Shift: &shift},
+			status:   status,
+			expected: map[string]interface{}{"OffsetTemp": 1},
+		},
+		{
+			name:     "negative shift truncated",
+			conf:     dhvac.HvacConf{Shift: &negative},
+			status:   status,
+			expected: map[string]interface{}{"OffsetTemp": -1},
+		},
+		{
+			name:     "shift without step",
+			conf:     dhvac.HvacConf{Shift: &shift},
+			status:   dhvac.Hvac{},
+			expected: map[string]interface{}{},
+		},
+		{
+			name:     "target mode",
+			conf:     dhvac.HvacConf{TargetMode: &mode},
+			status:   status,
+			expected: map[string]interface{}{"OccManCmd": dhvac.OCCUPANCY_ECONOMY},
+		},
+		{
+			name: "setpoints",
+			conf: dhvac.HvacConf{
+				SetpointCoolOccupied:   &setpoint,
+				SetpointHeatOccupied:   &setpoint,
+				SetpointHeatInoccupied: &setpoint,
+				SetpointCoolInoccupied: &setpoint,
+				SetpointCoolStandby:    &setpoint,
+				SetpointHeatStandby:    &setpoint,
+			},
+			status: status,
+			expected: map[string]interface{}{
+				"SetpointOccCool":    float32(setpoint) / 10,
+				"SetpointOccHeat":    float32(setpoint) / 10,
+				"SetpointUnoccHeat":  float32(setpoint) / 10,
+				"SetpointUnoccCool":  float32(setpoint) / 10,
+				"SetpointStanbyCool": float32(setpoint) / 10,
+				"SetpointStanbyHeat": float32(setpoint) / 10,
+			},
+		},
+	}
+	for _, test := range tests {
+		cmd := mapping.command(test.conf, test.status)
+		values := commandValues(cmd)
+		if len(values) != len(test.expected) {
+			t.Errorf("%v: got %v, expected %v", test.name, values, test.expected)
+			continue
+		}
+		for name, expected := range test.expected {
+			if values[name] != expected {
+				t.Errorf("%v: %v got %v, expected %v", test.name, name, values[name], expected)
+			}
+		}
+	}
+}
+
+//commandValues return the set fields of a command by Go name
+func commandValues(cmd core.HvacCommand) map[string]interface{} {
+	values := make(map[string]interface{})
+	var collect func(v reflect.Value)
+	collect = func(v reflect.Value) {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() != reflect.Struct {
+				values[v.Type().Field(i).Name] = field.Elem().Interface()
+				continue
+			}
+			collect(field)
+		}
+	}
+	collect(reflect.ValueOf(cmd))
+	return values
+}
+
+func TestMergeMapping(t *testing.T) {
+	fields := []core.FieldMapping{
+		{Field: "SpaceTemp1", Direction: core.MappingRead, Path: "a", Scale: 10},
+		{Field: "HeatCool1", Direction: core.MappingRead, Path: "b"},
+		{Field: "HeatCool1", Direction: core.MappingRead, Path: "c"},
+		{Field: "TemperatureSelect", Direction: core.MappingRead, Path: "d", Plus: "Shift"},
+		{Field: "Temperature", Direction: core.MappingWrite, Path: "e"},
+	}
+	tests := []struct {
+		name      string
+		overrides []core.FieldMapping
+		expected  []string
+	}{
+		{
+			name:     "no override",
+			expected: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:      "replaced in place",
+			overrides: []core.FieldMapping{{Field: "SpaceTemp1", Direction: core.MappingRead, Path: "x"}},
+			expected:  []string{"x", "b", "c", "d", "e"},
+		},
+		{
+			name:      "all the entries of a field",
+			overrides: []core.FieldMapping{{Field: "HeatCool1", Direction: core.MappingRead, Path: "x"}, {Field: "HeatCool1", Direction: core.MappingRead, Path: "y"}},
+			expected:  []string{"a", "x", "y", "d", "e"},
+		},
+		{
+			name:      "same field other direction",
+			overrides: []core.FieldMapping{{Field: "Temperature", Direction: core.MappingRead, Path: "x"}},
+			expected:  []string{"a", "b", "c", "d", "e", "x"},
+		},
+		{
+			name:      "new fields appended in order",
+			overrides: []core.FieldMapping{{Field: "Shift", Direction: core.MappingRead, Path: "x"}, {Field: "CO2", Direction: core.MappingWrite, Path: "y"}, {Field: "Temperature", Direction: core.MappingWrite, Path: "z"}},
+			expected:  []string{"a", "b", "c", "d", "z", "x", "y"},
+		},
+	}
+	for _, test := range tests {
+		merged := mergeMapping(fields, test.overrides)
+		paths := []string{}
+		for _, field := range merged {
+			paths = append(paths, field.Path)
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("%v: got %v, expected %v", test.name, paths, test.expected)
+		}
+	}
+	if fields[0].Path != "a" {
+		t.Errorf("the default mapping was modified")
+	}
+}
+
+func TestNewMappingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		err     bool
+	}{
+		{name: "override", content: `[{"field": "SpaceTemp1", "direction": "read", "path": "loop.regulation.dischAirTemp", "scale": 100}]`},
+		{name: "invalid json", content: `[{"field": `, err: true},
+		{name: "unknown read field", content: `[{"field": "Missing", "direction": "read", "path": "loop.regulation.spaceTemp"}]`, err: true},
+		{name: "unknown read path", content: `[{"field": "SpaceTemp1", "direction": "read", "path": "loop.regulation.missing"}]`, err: true},
+		{name: "unknown plus", content: `[{"field": "TemperatureSelect", "direction": "read", "path": "loop.regulation.effectifSetPoint", "plus": "Missing"}]`, err: true},
+		{name: "unknown multiply", content: `[{"field": "Shift", "direction": "read", "path": "loop.regulation.offsetTemp", "multiply": "regulation.missing"}]`, err: true},
+		{name: "unknown write field", content: `[{"field": "Missing", "direction": "write", "path": "loop.regulation.spaceTemp"}]`, err: true},
+		{name: "unknown command", content: `[{"field": "Temperature", "direction": "write", "path": "regulation.temperOffsetStep"}]`, err: true},
+		{name: "unknown divide", content: `[{"field": "Shift", "direction": "write", "path": "loop.regulation.offsetTemp", "divide": "Missing"}]`, err: true},
+		{name: "invalid direction", content: `[{"field": "SpaceTemp1", "direction": "both", "path": "loop.regulation.spaceTemp"}]`, err: true},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, string(rune('a'+i))+".json")
+		err := ioutil.WriteFile(path, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapping, err := NewMapping(path)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		values := testValues()
+		values.Loop.Regulation.DischAirTemp = 12.5
+		status := dhvac.Hvac{}
+		mapping.FillStatus(&status, values)
+		if status.SpaceTemp1 != 1250 || status.SpaceCO2 != 650 {
+			t.Errorf("%v: got %v and %v, expected 1250 and 650", test.name, status.SpaceTemp1, status.SpaceCO2)
+		}
+	}
+
+	_, err = NewMapping(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Errorf("missing file: expected an error")
+	}
+}
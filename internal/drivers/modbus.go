@@ -0,0 +1,297 @@
+package drivers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/jsonpath"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/modbus"
+	"github.com/romana/rlog"
+)
+
+//ProductModbus product type reported for the controllers answering on Modbus
+const ProductModbus = "MODBUS"
+
+//modbusDriver controllers not yet on the REST firmware
+type modbusDriver struct {
+	conf      core.ModbusConfig
+	registers *core.RegisterMap
+	legacy    *restDriver
+	mapping   *Mapping
+	heartbeat *int
+}
+
+func init() {
+	Register(ProductModbus, newModbusDriver)
+}
+
+func newModbusDriver(conf Config) (Driver, error) {
+	if !conf.Bridge.Modbus.Enabled {
+		return nil, nil
+	}
+	registers, err := core.ReadRegisterMap(conf.Bridge.Modbus.RegisterMap)
+	if err != nil {
+		rlog.Error("Cannot parse Modbus register map " + err.Error())
+		return nil, err
+	}
+	return &modbusDriver{
+		conf:      conf.Bridge.Modbus,
+		registers: registers,
+		legacy: &restDriver{
+			password: conf.Service.ClientAPI.Password,
+			urlToken: conf.Service.ClientAPI.URLToken,
+			mapping:  conf.Mapping,
+		},
+		mapping:   conf.Mapping,
+		heartbeat: conf.Bridge.Injection.WindowHeartBeat,
+	}, nil
+}
+
+//client open a Modbus TCP session on a controller
+func (d *modbusDriver) client(IP string) (*modbus.Client, error) {
+	client := modbus.NewClient(IP, d.conf.Port, d.conf.UnitID, time.Duration(d.conf.Timeout)*time.Millisecond)
+	err := client.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+//Protocol return MODBUS
+func (d *modbusDriver) Protocol() string {
+	return core.ProtocolModbus
+}
+
+//Probe read the register map probe point
+func (d *modbusDriver) Probe(IP string) (*core.HvacSysInfo, error) {
+	client, err := d.client(IP)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	_, err = client.ReadPoint(d.registers.Probe)
+	if err != nil {
+		return nil, err
+	}
+	return &core.HvacSysInfo{
+		ProductType: ProductModbus,
+	}, nil
+}
+
+//Login check that the controller accepts connections, Modbus has no session token
+func (d *modbusDriver) Login(IP string) (string, error) {
+	client, err := d.client(IP)
+	if err != nil {
+		return "", err
+	}
+	client.Close()
+	return "", nil
+}
+
+//ReadStatus read every point of the register map into the status
+func (d *modbusDriver) ReadStatus(status *dhvac.Hvac, token string) error {
+	client, err := d.client(status.IP)
+	if err != nil {
+		rlog.Error("Cannot connect to " + status.Mac + " " + err.Error())
+		return err
+	}
+	defer client.Close()
+
+	points, err := client.ReadPoints(d.registers.Points)
+	if err != nil {
+		rlog.Error("Cannot get Modbus registers from " + status.Mac + " " + err.Error())
+		return err
+	}
+	values := core.HvacValues{
+		Running: true,
+	}
+	jsonpath.Assign(points, "", &values)
+	d.mapping.FillStatus(status, values)
+	return nil
+}
+
+//ApplySetup write the setup points
+func (d *modbusDriver) ApplySetup(setup dhvac.HvacSetup, IP string, token string) error {
+	values := make(map[string]float64)
+	if setup.TemperatureOffsetStep != nil {
+		mergePoints(values, jsonpath.Flatten("regulation", regulationParam(setup)))
+	}
+	mergePoints(values, jsonpath.Flatten("inputs", inputsParam(setup)))
+	mergePoints(values, jsonpath.Flatten("outputs", outputsParam(setup)))
+	mergePoints(values, jsonpath.Flatten("setpoints", initSetpointsParam(setup)))
+	mergePoints(values, jsonpath.Flatten("airRegister", airQualityParam(setup)))
+	return d.write(setup.Mac, IP, values)
+}
+
+//ApplyRegulation write the regulation setup points
+func (d *modbusDriver) ApplyRegulation(setup dhvac.HvacSetup, IP string, token string) error {
+	if setup.TemperatureOffsetStep == nil {
+		return nil
+	}
+	return d.write(setup.Mac, IP, jsonpath.Flatten("regulation", regulationParam(setup)))
+}
+
+//ReadAirRegister read the air register setup points, the values without point are left unset
+func (d *modbusDriver) ReadAirRegister(IP string, token string) (*core.HvacSetupAirQualityCtrl, error) {
+	config := core.HvacSetupAirQualityCtrl{}
+	points := selectPoints(d.registers.Points, "airRegister")
+	if len(points) == 0 {
+		return &config, nil
+	}
+	client, err := d.client(IP)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	values, err := client.ReadPoints(points)
+	if err != nil {
+		rlog.Error("Cannot get Modbus airRegister registers from " + IP + " " + err.Error())
+		return nil, err
+	}
+	jsonpath.Assign(values, "airRegister", &config)
+	return &config, nil
+}
+
+//ApplyAirRegister write the air register setup points
+func (d *modbusDriver) ApplyAirRegister(mac string, config core.HvacSetupAirQualityCtrl, IP string, token string) error {
+	return d.write(mac, IP, jsonpath.Flatten("airRegister", config))
+}
+
+//ApplyConf write the runtime and setpoints points, the test mode is not available
+func (d *modbusDriver) ApplyConf(conf dhvac.HvacConf, status dhvac.Hvac, token string) error {
+	param := d.mapping.runtimeParam(conf, status)
+	injectionParam(&param, d.heartbeat)
+	if conf.HeatCool != nil {
+		if *conf.HeatCool == dhvac.HVAC_MODE_TEST {
+			rlog.Warn("Test mode is not available over Modbus on " + status.Mac)
+		} else {
+			if param.Regulation == nil {
+				param.Regulation = &core.HvacRegulationCtrl{}
+			}
+			param.Regulation.HeatCool = conf.HeatCool
+		}
+	}
+	values := jsonpath.Flatten("loop", param)
+	mergePoints(values, jsonpath.Flatten("setpoints", d.mapping.setpointsParam(conf)))
+	return d.write(status.Mac, status.IP, values)
+}
+
+//ReadLoops read the loops after the first one, a loop N is described by the "loopN" and "setpointsN" points
+func (d *modbusDriver) ReadLoops(status dhvac.Hvac, token string) ([]core.HvacLoopStatus, error) {
+	loops := []core.HvacLoopStatus{}
+	for loop := 2; loop <= core.MaxLoops; loop++ {
+		runtime := "loop" + strconv.Itoa(loop)
+		setpoints := "setpoints" + strconv.Itoa(loop)
+		points := selectPoints(d.registers.Points, runtime, setpoints)
+		if len(points) == 0 {
+			continue
+		}
+		client, err := d.client(status.IP)
+		if err != nil {
+			return nil, err
+		}
+		values, err := client.ReadPoints(points)
+		client.Close()
+		if err != nil {
+			rlog.Error("Cannot get Modbus " + runtime + " registers from " + status.Mac + " " + err.Error())
+			return nil, err
+		}
+		info := core.HvacLoop1{}
+		jsonpath.Assign(values, runtime, &info)
+		infoSetpoints := core.HvacSetPointsValues{}
+		jsonpath.Assign(values, setpoints, &infoSetpoints)
+		loops = append(loops, LoopStatus(status.Mac, loop, info, infoSetpoints))
+	}
+	return loops, nil
+}
+
+//ApplyLoopConf write the runtime and setpoints points of a regulation loop
+func (d *modbusDriver) ApplyLoopConf(conf core.HvacLoopConf, status dhvac.Hvac, token string) error {
+	err := CheckLoop(conf.Loop)
+	if err != nil {
+		return err
+	}
+	if conf.Loop == 1 {
+		return d.ApplyConf(LoopConf(conf), status, token)
+	}
+	runtime := "loop" + strconv.Itoa(conf.Loop)
+	occManCmd := 0
+	if conf.Presence != nil {
+		register, ok := d.registers.Points[runtime+".regulation.occManCmd"]
+		if ok {
+			client, err := d.client(status.IP)
+			if err != nil {
+				return err
+			}
+			value, err := client.ReadPoint(register)
+			client.Close()
+			if err != nil {
+				return err
+			}
+			occManCmd = int(value)
+		}
+	}
+	param, err := d.mapping.secondaryLoopParam(conf, occManCmd)
+	if err != nil {
+		return err
+	}
+	values := jsonpath.Flatten(runtime, param)
+	mergePoints(values, jsonpath.Flatten("setpoints"+strconv.Itoa(conf.Loop), d.mapping.setpointsParam(LoopConf(conf))))
+	return d.write(status.Mac, status.IP, values)
+}
+
+//Reboot is not available over Modbus
+func (d *modbusDriver) Reboot(IP string, token string) error {
+	return NewError("Reboot is not available over Modbus on " + IP)
+}
+
+//Raw the REST API is not available on the Modbus firmware
+func (d *modbusDriver) Raw(IP string, token string, request core.RawRequest) (*core.RawResponse, error) {
+	return nil, NewError("REST API is not available over Modbus on " + IP)
+}
+
+//Update use the firmware update API of the Modbus era controllers
+func (d *modbusDriver) Update(update core.FirmwareUpdate, tftpServer string) error {
+	return d.legacy.updateHvac(update.IP, tftpServer)
+}
+
+func (d *modbusDriver) write(mac string, IP string, values map[string]float64) error {
+	if len(values) == 0 {
+		rlog.Infof("No new Modbus registers to set skip it %v", mac)
+		return nil
+	}
+	client, err := d.client(IP)
+	if err != nil {
+		rlog.Error("Cannot connect to " + mac + " " + err.Error())
+		return err
+	}
+	defer client.Close()
+	rlog.Infof("Send Modbus parameters to HVAC %v: %v", mac, values)
+	err = client.WritePoints(d.registers.Points, values)
+	if err != nil {
+		rlog.Error("Cannot write Modbus registers on " + mac + " " + err.Error())
+	}
+	return err
+}
+
+//selectPoints return the points below one of the prefixes
+func selectPoints(points map[string]core.ModbusRegister, prefixes ...string) map[string]core.ModbusRegister {
+	selected := make(map[string]core.ModbusRegister)
+	for name, register := range points {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix+".") {
+				selected[name] = register
+			}
+		}
+	}
+	return selected
+}
+
+func mergePoints(values map[string]float64, others map[string]float64) {
+	for name, value := range others {
+		values[name] = value
+	}
+}
@@ -0,0 +1,1238 @@
+package drivers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/romana/rlog"
+)
+
+//restDriver controllers with the REST firmware
+type restDriver struct {
+	password  string
+	urlToken  string
+	mapping   *Mapping
+	heartbeat *int
+}
+
+func init() {
+	Register(DefaultProduct, newRestDriver)
+}
+
+func newRestDriver(conf Config) (Driver, error) {
+	return &restDriver{
+		password:  conf.Service.ClientAPI.Password,
+		urlToken:  conf.Service.ClientAPI.URLToken,
+		mapping:   conf.Mapping,
+		heartbeat: conf.Bridge.Injection.WindowHeartBeat,
+	}, nil
+}
+
+//Protocol return REST
+func (d *restDriver) Protocol() string {
+	return core.ProtocolREST
+}
+
+//Probe login and read the system information
+func (d *restDriver) Probe(IP string) (*core.HvacSysInfo, error) {
+	token, err := d.hvacLogin(IP)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.hvacGetVersion(IP, token)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, NewError("No system information")
+	}
+	return info, nil
+}
+
+//Login return the access token
+func (d *restDriver) Login(IP string) (string, error) {
+	return d.hvacLogin(IP)
+}
+
+//ReadStatus read loop1 runtime, setpoints, inputs and outputs into the status
+func (d *restDriver) ReadStatus(status *dhvac.Hvac, token string) error {
+	values := core.HvacValues{}
+	info, err := d.hvacGetStatus(status.IP, token, 1)
+	if err != nil {
+		rlog.Error("Cannot get status from " + status.Mac)
+		return err
+	}
+	values.Loop = *info
+	time.Sleep(50 * time.Millisecond)
+	maintenance, _ := d.getHvacMaintenanceMode(status.IP, token)
+	if maintenance == nil {
+		rlog.Error("Cannot get maintenance info from " + status.Mac)
+		return NewError("Cannot get maintenance info from " + status.Mac)
+	}
+	values.Running = maintenance.Running
+	time.Sleep(50 * time.Millisecond)
+	infoSetpoint, err := d.getHvacSetpoints(status.IP, token, 1)
+	if err != nil {
+		rlog.Error("Cannot get hvacSetpoints info from " + status.Mac)
+		return err
+	}
+	values.Setpoints = *infoSetpoint
+	time.Sleep(50 * time.Millisecond)
+	infoRegul, err := d.getHvacSetupRegulation(status.IP, token)
+	if err != nil {
+		rlog.Error("Cannot get hvacSetupRegulation info from " + status.Mac)
+		return err
+	}
+	values.Regulation = *infoRegul
+	time.Sleep(50 * time.Millisecond)
+	inputValues, err := d.getHvacSetupInputs(status.IP, token)
+	if err != nil {
+		rlog.Error("Cannot get hvacSetupInputs info from " + status.Mac)
+		return err
+	}
+	values.Inputs = *inputValues
+	time.Sleep(50 * time.Millisecond)
+	outputValues, err := d.getHvacSetupOutputs(status.IP, token)
+	if err != nil {
+		rlog.Error("Cannot get hvacSetupOutputs info from " + status.Mac)
+		return err
+	}
+	values.Outputs = *outputValues
+
+	time.Sleep(50 * time.Millisecond)
+	testValues, err := d.getHvacMaintenanceOutputs(status.IP, token)
+	if err != nil {
+		rlog.Error("Cannot get maintenance output info from " + status.Mac)
+		return err
+	}
+	values.Maintenance = *testValues
+	time.Sleep(100 * time.Millisecond)
+	infoVersion, err := d.hvacGetVersion(status.IP, token)
+	if err != nil || infoVersion == nil {
+		rlog.Error("Cannot get info version from " + status.Mac)
+		return NewError("Cannot get info version from " + status.Mac)
+	}
+	values.SoftwareVersion = infoVersion.SoftwareVersion
+
+	d.mapping.FillStatus(status, values)
+	return nil
+}
+
+//ApplyRegulation send the regulation setup, e.g. to change the temperature selection
+func (d *restDriver) ApplyRegulation(setup dhvac.HvacSetup, IP string, token string) error {
+	return d.setHvacSetupRegulation(setup, IP, token)
+}
+
+//ReadAirRegister read the air register setup
+func (d *restDriver) ReadAirRegister(IP string, token string) (*core.HvacSetupAirQualityCtrl, error) {
+	return d.getHvacSetupAirRegister(IP, token)
+}
+
+//ApplyAirRegister send the air register setup, e.g. to change the fresh air damper opening
+func (d *restDriver) ApplyAirRegister(mac string, config core.HvacSetupAirQualityCtrl, IP string, token string) error {
+	return d.postAirRegister(mac, config, IP, token)
+}
+
+//ApplyConf send the runtime values and the setpoints
+func (d *restDriver) ApplyConf(conf dhvac.HvacConf, status dhvac.Hvac, token string) error {
+	errRuntime := d.setHvacRuntime(conf, status, status.IP, token)
+	err := d.hvacSetAFConfig(conf, status.IP, token, 1)
+	if errRuntime != nil {
+		return errRuntime
+	}
+	return err
+}
+
+//ReadLoops read the runtime and setpoints of the loops used after the first one
+func (d *restDriver) ReadLoops(status dhvac.Hvac, token string) ([]core.HvacLoopStatus, error) {
+	regul, err := d.getHvacSetupRegulation(status.IP, token)
+	if err != nil {
+		return nil, err
+	}
+	loops := []core.HvacLoopStatus{}
+	for loop := 2; loop <= regul.LoopsUsed && loop <= core.MaxLoops; loop++ {
+		time.Sleep(50 * time.Millisecond)
+		info, err := d.hvacGetStatus(status.IP, token, loop)
+		if err != nil {
+			rlog.Error("Cannot get loop" + strconv.Itoa(loop) + " status from " + status.Mac)
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+		setpoints, err := d.getHvacSetpoints(status.IP, token, loop)
+		if err != nil {
+			rlog.Error("Cannot get loop" + strconv.Itoa(loop) + " setpoints from " + status.Mac)
+			return nil, err
+		}
+		loops = append(loops, LoopStatus(status.Mac, loop, *info, *setpoints))
+	}
+	return loops, nil
+}
+
+//ApplyLoopConf send the runtime values and the setpoints of a regulation loop
+func (d *restDriver) ApplyLoopConf(conf core.HvacLoopConf, status dhvac.Hvac, token string) error {
+	err := CheckLoop(conf.Loop)
+	if err != nil {
+		return err
+	}
+	if conf.Loop == 1 {
+		return d.ApplyConf(LoopConf(conf), status, token)
+	}
+	occManCmd := 0
+	if conf.Presence != nil {
+		info, err := d.hvacGetStatus(status.IP, token, conf.Loop)
+		if err != nil {
+			return err
+		}
+		occManCmd = info.Regulation.OccManCmd
+	}
+	param, err := d.mapping.secondaryLoopParam(conf, occManCmd)
+	if err != nil {
+		return err
+	}
+	if (core.HvacLoopCtrl{}) != param {
+		err = d.sendHvacRuntime(status.Mac, status.IP, token, conf.Loop, param)
+		if err != nil {
+			return err
+		}
+	}
+	return d.hvacSetAFConfig(LoopConf(conf), status.IP, token, conf.Loop)
+}
+
+//Reboot restart the controller, it also leaves the test mode
+func (d *restDriver) Reboot(IP string, token string) error {
+	_, err := d.setHvacMaintenanceBackMode(IP, token)
+	return err
+}
+
+//Update start the firmware download, with the modbus era API for legacy controllers
+func (d *restDriver) Update(update core.FirmwareUpdate, tftpServer string) error {
+	if update.Legacy {
+		return d.updateHvac(update.IP, tftpServer)
+	}
+	token, err := d.hvacLogin(update.IP)
+	if err != nil {
+		return err
+	}
+	return d.updateHvacNewAPI(update.IP, token, tftpServer)
+}
+
+//Raw forward a request to /api/ on the controller
+func (d *restDriver) Raw(IP string, token string, request core.RawRequest) (*core.RawResponse, error) {
+	url := "https://" + IP + "/api/" + request.Path
+	if request.Query != "" {
+		url += "?" + request.Query
+	}
+
+	req, err := http.NewRequest(request.Method, url, bytes.NewBuffer(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	contentType := request.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &core.RawResponse{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	}, nil
+}
+
+//ApplySetup send the initial setup
+func (d *restDriver) ApplySetup(setup dhvac.HvacSetup, IP string, token string) error {
+	err := d.setHvacSetupRegulation(setup, IP, token)
+	if err != nil {
+		rlog.Error("Cannot apply init config: ", err.Error())
+		return err
+	}
+	err = d.setHvacSetupInputs(setup, IP, token)
+	if err != nil {
+		rlog.Error("Cannot apply inputs config: ", err.Error())
+		return err
+	}
+	err = d.setHvacSetupOutputs(setup, IP, token)
+	if err != nil {
+		rlog.Error("Cannot apply outputs config: ", err.Error())
+		return err
+	}
+	err = d.hvacInit(setup, IP, token)
+	if err != nil {
+		rlog.Error("Cannot apply init config: ", err.Error())
+		return err
+	}
+	err = d.setHvacSetupAirRegister(setup, IP, token)
+	if err != nil {
+		rlog.Error("Cannot airRegister config: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (d *restDriver) updateHvac(IP string, tftpServer string) error {
+	url := "http://" + IP + ":3000/api/updateParam"
+
+	config := core.HvacUpdateParams{
+		TftpServerIP: tftpServer,
+		StartUpdate:  true,
+	}
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("x-access-token", d.urlToken)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received UpdateHvac status code %v, body %v", IP, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code: " + strconv.Itoa((resp.StatusCode)))
+	}
+	rlog.Info("Update triggered on ", IP)
+	return nil
+}
+
+func (d *restDriver) updateHvacNewAPI(IP string, token string, tftpServer string) error {
+	url := "https://" + IP + "/api/updateParam"
+
+	config := core.HvacUpdateParams{
+		TftpServerIP: tftpServer,
+		StartUpdate:  true,
+	}
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("x-access-token", token)
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received updateHvacNewAPI status code %v, body %v", IP, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code: " + strconv.Itoa((resp.StatusCode)))
+	}
+	rlog.Info("Update triggered on ", IP)
+	return nil
+}
+
+func (d *restDriver) hvacGetStatus(IP string, token string, loop int) (*core.HvacLoop1, error) {
+	url := "https://" + IP + "/api/runtime/hvac/loop" + strconv.Itoa(loop)
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json")
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received hvacGetStatus status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	info := core.HvacLoop1{}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (d *restDriver) hvacGetVersion(IP string, token string) (*core.HvacSysInfo, error) {
+	url := "https://" + IP + "/api/systemInfos"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error("Cannot get version: " + err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received hvacGetVersion status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	info := core.HvacSysInfo{}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		rlog.Error("Cannot get version: " + err.Error())
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (d *restDriver) hvacLogin(IP string) (string, error) {
+	url := "https://" + IP + "/api/login"
+
+	user := core.HvacLogin{
+		UserKey: d.password,
+	}
+
+	requestBody, err := json.Marshal(user)
+	if err != nil {
+		rlog.Error("Cannot send request to: " + err.Error())
+		return "", err
+	}
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error("Cannot send request to: " + err.Error())
+		return "", err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received hvacLogin status code %v, body %v", IP, resp.StatusCode, string(body))
+		return "", NewError("Incorrect Status code")
+	}
+
+	auth := core.HvacAuth{}
+	err = json.Unmarshal(body, &auth)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return "", err
+	}
+	return auth.AccessToken, nil
+}
+
+func (d *restDriver) getHvacSetpoints(IP string, token string, loop int) (*core.HvacSetPointsValues, error) {
+	url := "https://" + IP + "/api/setup/hvac/setpoint/loop" + strconv.Itoa(loop)
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacSetpoints status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	status := core.HvacSetPointsValues{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) setHvacRuntime(conf dhvac.HvacConf, status dhvac.Hvac, IP string, token string) error {
+	loopHvac := false
+	maintenance, _ := d.getHvacMaintenanceMode(status.IP, token)
+	if maintenance != nil {
+		loopHvac = maintenance.Running
+	}
+	param := d.mapping.runtimeParam(conf, status)
+	injectionParam(&param, d.heartbeat)
+
+	if conf.HeatCool != nil {
+		// 0 = HC_MODE_AUTO
+		// 1 = HC_MODE_HEAT
+		// 3 = HC_MODE_COOL
+		// 6 = HC_MODE_OFF
+		// 7 = HC_MODE_TEST
+		// 8 = HC_MODE_EMERGENCY_HEAT
+
+		if *conf.HeatCool != dhvac.HVAC_MODE_TEST {
+			if loopHvac == true {
+				if param.Regulation == nil {
+					airReg := core.HvacRegulationCtrl{}
+					param.Regulation = &airReg
+				}
+				param.Regulation.HeatCool = conf.HeatCool
+			} else {
+				_, err := d.setHvacMaintenanceBackMode(status.IP, token)
+				if err != nil {
+					rlog.Error("Cannot leave test mode", err.Error())
+					return err
+				}
+				rlog.Info("HVAC leave test mode", status.Mac)
+			}
+		} else {
+			rlog.Info("HVAC enter in test mode", status.Mac)
+			err := d.setHvacMaintenanceMode(conf, status, status.IP, token)
+			if err != nil {
+				rlog.Error("Cannot switch in test mode", err)
+				return err
+			}
+			err = d.setHvacMaintenanceParam(conf, status, status.IP, token)
+			if err != nil {
+				rlog.Error("Cannot prepare test mode", err)
+				return err
+			}
+		}
+		rlog.Infof("Switch HVAC " + status.Mac + ": in " + strconv.Itoa(*conf.HeatCool))
+	}
+
+	if (conf.HeatCool == nil || *conf.HeatCool == dhvac.HVAC_MODE_TEST) && loopHvac == false {
+		err := d.setHvacMaintenanceParam(conf, status, status.IP, token)
+		if err != nil {
+			rlog.Error("Cannot send in test mode parameters ", err)
+			return err
+		}
+	}
+
+	if conf.ForcingAutoBack != nil {
+		if *conf.ForcingAutoBack == 1 {
+			d.setHvacMaintenanceBackMode(status.IP, token)
+			rlog.Info("HVAC leave test mode", status.Mac)
+		}
+	}
+
+	if (core.HvacLoopCtrl{}) == param {
+		rlog.Infof("No new setHvacRuntime to set skip it %v: %v", status.Mac, param)
+		return nil
+	}
+
+	return d.sendHvacRuntime(status.Mac, IP, token, 1, param)
+}
+
+//sendHvacRuntime post the runtime values of a regulation loop
+func (d *restDriver) sendHvacRuntime(mac string, IP string, token string, loop int, param core.HvacLoopCtrl) error {
+	url := "https://" + IP + "/api/runtime/hvac/loop" + strconv.Itoa(loop)
+
+	requestBody, err := json.Marshal(param)
+	if err != nil {
+		return err
+	}
+	rlog.Infof("Send new parameters to HVAC %v: %v", mac, string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received sendHvacRuntime status code %v, body %v", mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+
+	return nil
+}
+
+func (d *restDriver) setHvacMaintenanceParam(conf dhvac.HvacConf, status dhvac.Hvac, IP string, token string) error {
+	//Prepare Maintenance Outputs
+	urlTask := "https://" + IP + "/api/maintenance/outputs"
+
+	requestBodyTask := `{"output_Ya": 100, "output_Yb": 100`
+	if conf.Forcing6waysValve != nil {
+		requestBodyTask += `, "output_Y5": ` + strconv.Itoa(*conf.Forcing6waysValve)
+	}
+	if conf.ForcingDamper != nil {
+		requestBodyTask += `, "output_Y6": ` + strconv.Itoa(*conf.ForcingDamper)
+	}
+	requestBodyTask += `}`
+	rlog.Infof("Send HVAC test Mode parameters " + status.Mac + " : " + string(requestBodyTask))
+
+	req, _ := http.NewRequest("POST", urlTask, strings.NewReader(requestBodyTask))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacMaintenanceParam status code %v, body %v", conf.Mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+	return nil
+}
+
+func (d *restDriver) setHvacMaintenanceMode(conf dhvac.HvacConf, status dhvac.Hvac, IP string, token string) error {
+	urlTask := "https://" + IP + "/api/maintenance/hvacTaskStatus"
+
+	body := strings.NewReader(`{"running": false}`)
+
+	rlog.Infof("Send new parameters to HVAC %v: %v", status.Mac, `{"running": false}`)
+	req, _ := http.NewRequest("POST", urlTask, body)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacMaintenanceMode status code %v, body %v", conf.Mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+	return nil
+}
+
+func (d *restDriver) setHvacMaintenanceBackMode(IP string, token string) (*core.HvacTask, error) {
+	url := "https://" + IP + "/api/reboot"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacMaintenanceBackMode status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	status := core.HvacTask{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) getHvacMaintenanceMode(IP string, token string) (*core.HvacTask, error) {
+	url := "https://" + IP + "/api/maintenance/hvacTaskStatus"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacMaintenanceMode status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	status := core.HvacTask{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) setHvacSetupAirRegister(setup dhvac.HvacSetup, IP string, token string) error {
+	config := airQualityParam(setup)
+	if (core.HvacSetupAirQualityCtrl{}) == config {
+		rlog.Infof("No new setHvacSetupAirRegister to set skip it %v: %v", setup.Mac, config)
+		return nil
+	}
+	return d.postAirRegister(setup.Mac, config, IP, token)
+}
+
+func (d *restDriver) postAirRegister(mac string, config core.HvacSetupAirQualityCtrl, IP string, token string) error {
+	url := "https://" + IP + "/api/setup/hvac/airRegister"
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	rlog.Infof("Send HVAC AirRegister parameters " + mac + " : " + string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacSetupAirRegister status code %v, body %v", mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+
+	return nil
+}
+
+func (d *restDriver) setHvacSetupRegulation(setup dhvac.HvacSetup, IP string, token string) error {
+	url := "https://" + IP + "/api/setup/hvac/regulation"
+
+	if setup.TemperatureOffsetStep == nil {
+		return nil
+	}
+
+	config := regulationParam(setup)
+
+	if (core.HvacSetupRegulationCtrl{}) == config {
+		rlog.Infof("No new HvacSetupRegulationCtrl to set skip it %v: %v", setup.Mac, config)
+		return nil
+	}
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	rlog.Infof("Send HVAC parameters " + setup.Mac + " : " + string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacSetupRegulation status code %v, body %v", setup.Mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+
+	return nil
+}
+
+func (d *restDriver) setHvacSetupInputs(setup dhvac.HvacSetup, IP string, token string) error {
+	url := "https://" + IP + "/api/setup/inputs"
+
+	config := inputsParam(setup)
+
+	if (core.HvacInput{}) == config {
+		rlog.Infof("No new setHvacSetupInputs to set skip it %v: %v", setup.Mac, config)
+		return nil
+	}
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	rlog.Infof("Send HVAC parameters " + setup.Mac + " : " + string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacSetupInputs status code %v, body %v", setup.Mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+
+	return nil
+}
+
+func (d *restDriver) setHvacSetupOutputs(setup dhvac.HvacSetup, IP string, token string) error {
+	url := "https://" + IP + "/api/setup/outputs"
+
+	config := outputsParam(setup)
+
+	if (core.HvacOutput{}) == config {
+		rlog.Infof("No new HvacOutput to set skip it %v: %v", setup.Mac, config)
+		return nil
+	}
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	rlog.Infof("Send HVAC parameters " + setup.Mac + " : " + string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received setHvacSetupInputs status code %v, body %v", setup.Mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+
+	return nil
+}
+
+func (d *restDriver) getHvacSetupAirRegister(IP string, token string) (*core.HvacSetupAirQualityCtrl, error) {
+	url := "https://" + IP + "/api/setup/hvac/airRegister"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacSetupAirRegister status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	config := core.HvacSetupAirQualityCtrl{}
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (d *restDriver) getHvacSetupRegulation(IP string, token string) (*core.HvacSetupRegulation, error) {
+	url := "https://" + IP + "/api/setup/hvac/regulation"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacSetupRegulation status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	status := core.HvacSetupRegulation{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) getHvacSetupInputs(IP string, token string) (*core.HvacInputValues, error) {
+	url := "https://" + IP + "/api/setup/inputs"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacSetupInputs status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	status := core.HvacInputValues{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) getHvacMaintenanceOutputs(IP string, token string) (*core.HvacOutputValues, error) {
+	url := "https://" + IP + "/api/maintenance/outputs"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacMaintenanceOutputs status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	status := core.HvacOutputValues{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) getHvacSetupOutputs(IP string, token string) (*core.HvacOutputValues, error) {
+	url := "https://" + IP + "/api/setup/outputs"
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Close = true
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		rlog.Errorf("%v Received getHvacSetupOutputs status code %v, body %v", IP, resp.StatusCode, string(body))
+		return nil, NewError("Incorrect Status code")
+	}
+
+	status := core.HvacOutputValues{}
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		rlog.Error("Cannot parse body: " + err.Error())
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (d *restDriver) hvacInit(setup dhvac.HvacSetup, IP string, token string) error {
+	url := "https://" + IP + "/api/setup/hvac/setpoint/loop1"
+
+	config := initSetpointsParam(setup)
+
+	if (core.HvacSetPoints{}) == config {
+		rlog.Infof("No new Setpoint in HvacInit to set skip it %v: %v", setup.Mac, config)
+		return nil
+	}
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	rlog.Infof("Send HVAC parameters " + setup.Mac + " : " + string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Close = true
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		rlog.Errorf("%v Received hvacInit status code %v, body %v", setup.Mac, resp.StatusCode, string(body))
+		return NewError("Incorrect Status code")
+	}
+	return nil
+}
+
+func (d *restDriver) hvacSetAFConfig(setup dhvac.HvacConf, IP string, token string, loop int) error {
+	url := "https://" + IP + "/api/setup/hvac/setpoint/loop" + strconv.Itoa(loop)
+
+	config := d.mapping.setpointsParam(setup)
+
+	if (core.HvacSetPoints{}) == config {
+		rlog.Infof("No new Setpoint to set skip it %v : %v", setup.Mac, config)
+		return nil
+	}
+
+	requestBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	rlog.Infof("Send HVAC parameters " + setup.Mac + " : " + string(requestBody))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req.Close = true
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("authorization", "Bearer "+token)
+	transCfg := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // ignore expired SSL certificates
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transCfg}
+	resp, err := client.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	if err != nil {
+		rlog.Error(err.Error())
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		rlog.Errorf("%v Received hvacSetAFConfig status code %v, body %v", setup.Mac, resp.StatusCode, string(body))
+
+		return NewError("Incorrect Status code: " + strconv.Itoa((resp.StatusCode)) + " : " + string(body))
+	}
+	return nil
+}
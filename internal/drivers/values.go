@@ -0,0 +1,247 @@
+package drivers
+
+import (
+	"strconv"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+//runtimeParam build the loop1 runtime values of a configuration
+//The presence depends on the occupancy mode, the heat/cool mode is left to the caller as it may need the test mode
+func (m *Mapping) runtimeParam(conf dhvac.HvacConf, status dhvac.Hvac) core.HvacLoopCtrl {
+	param := m.command(conf, status).Loop
+
+	if conf.Presence != nil {
+		if status.OccManCmd1 == dhvac.OCCUPANCY_STANDBY ||
+			status.OccManCmd1 == dhvac.OCCUPANCY_COMFORT ||
+			((conf.TargetMode != nil) && ((*conf.TargetMode == dhvac.OCCUPANCY_STANDBY) || (*conf.TargetMode == dhvac.OCCUPANCY_COMFORT))) {
+			//The presence is only takes into account in Standby mode
+			if param.Regulation == nil {
+				airReg := core.HvacRegulationCtrl{}
+				param.Regulation = &airReg
+			}
+			pres := *conf.Presence
+			if pres {
+				mode := dhvac.OCCUPANCY_COMFORT
+				param.Regulation.OccManCmd = &mode
+			} else {
+				mode := dhvac.OCCUPANCY_STANDBY
+				param.Regulation.OccManCmd = &mode
+			}
+		}
+	}
+
+	return param
+}
+
+//injectionParam send the heartbeat window with the injected measures
+//The controller stops using them when they are not written again within the window
+func injectionParam(param *core.HvacLoopCtrl, heartbeat *int) {
+	if heartbeat == nil {
+		return
+	}
+	injected := param.AirRegister != nil && (param.AirRegister.SpaceCO2 != nil || param.AirRegister.SpaceHygroRel != nil)
+	if param.Regulation != nil && param.Regulation.SpaceTemp != nil {
+		injected = true
+	}
+	if !injected {
+		return
+	}
+	if param.Regulation == nil {
+		param.Regulation = &core.HvacRegulationCtrl{}
+	}
+	param.Regulation.WindowHeartBeat = heartbeat
+}
+
+//airQualityParam build the air register setup
+func airQualityParam(setup dhvac.HvacSetup) core.HvacSetupAirQualityCtrl {
+	hygroMode := 1
+	config := core.HvacSetupAirQualityCtrl{
+		HygroMode: &hygroMode,
+	}
+	if setup.OaDamperMode != nil {
+		config.OADamperMode = setup.OaDamperMode
+	}
+	if setup.CO2Mode != nil {
+		config.CO2Mode = setup.CO2Mode
+	}
+	if setup.CO2Max != nil {
+		config.CO2Max = setup.CO2Max
+	}
+	return config
+}
+
+//regulationParam build the regulation setup, TemperatureOffsetStep is mandatory
+func regulationParam(setup dhvac.HvacSetup) core.HvacSetupRegulationCtrl {
+	offset := float32(*setup.TemperatureOffsetStep) / 10.0
+	return core.HvacSetupRegulationCtrl{
+		TemperOffsetStep:  &offset,
+		TemperatureSelect: setup.TemperatureSelection,
+		RegulType:         setup.RegulationType,
+		LoopsUsed:         setup.LoopUsed,
+	}
+}
+
+//inputsParam build the inputs setup
+func inputsParam(setup dhvac.HvacSetup) core.HvacInput {
+	config := core.HvacInput{}
+
+	if setup.InputE1 != nil {
+		config.InputE1 = setup.InputE1
+	}
+	if setup.InputE2 != nil {
+		config.InputE2 = setup.InputE2
+	}
+	if setup.InputE3 != nil {
+		config.InputE3 = setup.InputE3
+	}
+	if setup.InputE4 != nil {
+		config.InputE4 = setup.InputE4
+	}
+	if setup.InputE5 != nil {
+		config.InputE5 = setup.InputE5
+	}
+	if setup.InputE6 != nil {
+		config.InputE6 = setup.InputE6
+	}
+	if setup.InputC1 != nil {
+		config.InputC1 = setup.InputC1
+	}
+	if setup.InputC2 != nil {
+		config.InputC2 = setup.InputC2
+	}
+	return config
+}
+
+//outputsParam build the outputs setup
+func outputsParam(setup dhvac.HvacSetup) core.HvacOutput {
+	config := core.HvacOutput{}
+
+	if setup.OutputY5 != nil {
+		config.OutputY5 = setup.OutputY5
+	}
+	if setup.OutputY6 != nil {
+		config.OutputY6 = setup.OutputY6
+	}
+	if setup.OutputY7 != nil {
+		config.OutputY7 = setup.OutputY7
+	}
+	if setup.OutputY8 != nil {
+		config.OutputY8 = setup.OutputY8
+	}
+	if setup.OutputYa != nil {
+		config.OutputYa = setup.OutputYa
+	}
+	if setup.OutputYb != nil {
+		config.OutputYb = setup.OutputYb
+	}
+	return config
+}
+
+//initSetpointsParam build the loop1 setpoints with their default values
+func initSetpointsParam(setup dhvac.HvacSetup) core.HvacSetPoints {
+	OccCool := float32(19)
+	if setup.SetpointCoolOccupied != nil {
+		OccCool = float32(*setup.SetpointCoolOccupied) / 10
+	}
+	OccHeat := float32(26)
+	if setup.SetpointHeatOccupied != nil {
+		OccHeat = float32(*setup.SetpointHeatOccupied) / 10
+	}
+	UnoccHeat := float32(30)
+	if setup.SetpointHeatInoccupied != nil {
+		UnoccHeat = float32(*setup.SetpointHeatInoccupied) / 10
+	}
+	UnoccCool := float32(15)
+	if setup.SetpointCoolInoccupied != nil {
+		UnoccCool = float32(*setup.SetpointCoolInoccupied) / 10
+	}
+	CoolStandby := float32(28)
+	if setup.SetpointCoolStandby != nil {
+		CoolStandby = float32(*setup.SetpointCoolStandby) / 10
+	}
+	HeatStandby := float32(17)
+	if setup.SetpointHeatStandby != nil {
+		HeatStandby = float32(*setup.SetpointHeatStandby) / 10
+	}
+
+	config := core.HvacSetPoints{
+		SetpointOccCool:    &OccCool,
+		SetpointOccHeat:    &OccHeat,
+		SetpointUnoccHeat:  &UnoccHeat,
+		SetpointUnoccCool:  &UnoccCool,
+		SetpointStanbyCool: &CoolStandby,
+		SetpointStanbyHeat: &HeatStandby,
+	}
+	return config
+}
+
+//setpointsParam build the loop1 setpoints to change
+func (m *Mapping) setpointsParam(conf dhvac.HvacConf) core.HvacSetPoints {
+	return m.command(conf, dhvac.Hvac{}).Setpoints
+}
+
+//LoopStatus convert the values of a regulation loop
+func LoopStatus(mac string, loop int, info core.HvacLoop1, setpoints core.HvacSetPointsValues) core.HvacLoopStatus {
+	return core.HvacLoopStatus{
+		Mac:                    mac,
+		Loop:                   loop,
+		SpaceTemp:              int(info.Regulation.SpaceTemp * 10),
+		EffectSetPoint:         int(info.Regulation.EffectifSetPoint * 10),
+		OccManCmd:              info.Regulation.OccManCmd,
+		HeatCool:               info.Regulation.HeatCool,
+		HeatOutput:             info.Regulation.HeatOutput,
+		CoolOutput:             info.Regulation.CoolOutput,
+		HoldOff:                info.Regulation.WindowHoldOff,
+		DewSensor:              info.Regulation.DewSensor,
+		SetpointOccupiedCool:   int(setpoints.SetpointOccCool * 10),
+		SetpointOccupiedHeat:   int(setpoints.SetpointOccHeat * 10),
+		SetpointUnoccupiedCool: int(setpoints.SetpointUnoccCool * 10),
+		SetpointUnoccupiedHeat: int(setpoints.SetpointUnoccHeat * 10),
+		SetpointStandbyCool:    int(setpoints.SetpointStanbyCool * 10),
+		SetpointStandbyHeat:    int(setpoints.SetpointStanbyHeat * 10),
+	}
+}
+
+//LoopConf convert a loop command into the equivalent loop 1 configuration
+func LoopConf(conf core.HvacLoopConf) dhvac.HvacConf {
+	return dhvac.HvacConf{
+		Mac:                    conf.Mac,
+		WindowStatus:           conf.WindowStatus,
+		Temperature:            conf.Temperature,
+		Presence:               conf.Presence,
+		TargetMode:             conf.TargetMode,
+		HeatCool:               conf.HeatCool,
+		SetpointCoolOccupied:   conf.SetpointCoolOccupied,
+		SetpointHeatOccupied:   conf.SetpointHeatOccupied,
+		SetpointCoolInoccupied: conf.SetpointCoolInoccupied,
+		SetpointHeatInoccupied: conf.SetpointHeatInoccupied,
+		SetpointCoolStandby:    conf.SetpointCoolStandby,
+		SetpointHeatStandby:    conf.SetpointHeatStandby,
+	}
+}
+
+//secondaryLoopParam build the runtime values of a loop above 1, the test mode is only available on loop 1
+//occManCmd is the current occupancy of the loop used for the presence
+func (m *Mapping) secondaryLoopParam(conf core.HvacLoopConf, occManCmd int) (core.HvacLoopCtrl, error) {
+	param := m.runtimeParam(LoopConf(conf), dhvac.Hvac{OccManCmd1: occManCmd})
+	if conf.HeatCool != nil {
+		if *conf.HeatCool == dhvac.HVAC_MODE_TEST {
+			return param, NewError("Test mode is only available on loop 1")
+		}
+		if param.Regulation == nil {
+			param.Regulation = &core.HvacRegulationCtrl{}
+		}
+		param.Regulation.HeatCool = conf.HeatCool
+	}
+	return param, nil
+}
+
+//CheckLoop return an error when the loop index is not handled by the controllers
+func CheckLoop(loop int) error {
+	if loop < 1 || loop > core.MaxLoops {
+		return NewError("Invalid regulation loop " + strconv.Itoa(loop))
+	}
+	return nil
+}
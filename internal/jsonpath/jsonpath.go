@@ -0,0 +1,167 @@
+package jsonpath
+
+import (
+	"math"
+	"reflect"
+	"strings"
+)
+
+//Paths are the dot separated json names of nested struct fields, e.g. "regulation.spaceTemp"
+
+//Get return the value at a json path, false when a field is missing or a pointer is nil
+func Get(source interface{}, path string) (reflect.Value, bool) {
+	v := reflect.ValueOf(source)
+	for _, name := range strings.Split(path, ".") {
+		v = indirect(v)
+		if v.Kind() != reflect.Struct {
+			return v, false
+		}
+		field, ok := fieldByName(v, name)
+		if !ok {
+			return v, false
+		}
+		v = field
+	}
+	v = indirect(v)
+	return v, v.IsValid()
+}
+
+//Number return a numeric or boolean value as a float
+func Number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+//Set assign a number at a json path of target, nil pointers on the way are allocated
+//Integers are truncated toward zero like a Go conversion
+func Set(target interface{}, path string, value float64) bool {
+	v := reflect.ValueOf(target).Elem()
+	for _, name := range strings.Split(path, ".") {
+		v = allocate(v)
+		if v.Kind() != reflect.Struct {
+			return false
+		}
+		field, ok := fieldByName(v, name)
+		if !ok {
+			return false
+		}
+		v = field
+	}
+	return SetNumber(allocate(v), value)
+}
+
+//SetNumber assign a number to an int, float or bool value
+func SetNumber(v reflect.Value, value float64) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(math.Trunc(value)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(math.Trunc(value)))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(value)
+	case reflect.Bool:
+		v.SetBool(value != 0)
+	default:
+		return false
+	}
+	return true
+}
+
+//Flatten return the numbers of source named after their json path below prefix
+//Nil pointers are skipped
+func Flatten(prefix string, source interface{}) map[string]float64 {
+	values := make(map[string]float64)
+	flatten(values, prefix, reflect.ValueOf(source))
+	return values
+}
+
+func flatten(values map[string]float64, prefix string, v reflect.Value) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return
+	}
+	if v.Kind() == reflect.Struct {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			flatten(values, name, v.Field(i))
+		}
+		return
+	}
+	value, ok := Number(v)
+	if ok {
+		values[prefix] = value
+	}
+}
+
+//Assign set the fields of target from the values named after their json path below prefix
+func Assign(values map[string]float64, prefix string, target interface{}) {
+	for name, value := range values {
+		if prefix != "" {
+			if !strings.HasPrefix(name, prefix+".") {
+				continue
+			}
+			name = name[len(prefix)+1:]
+		}
+		Set(target, name, value)
+	}
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func allocate(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func fieldByName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return v, false
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" || field.PkgPath != "" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
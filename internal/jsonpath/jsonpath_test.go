@@ -0,0 +1,157 @@
+package jsonpath
+
+import (
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Value   float32 `json:"value"`
+	Count   *int    `json:"count,omitempty"`
+	Enabled bool    `json:"enabled"`
+}
+
+type outer struct {
+	Name     string `json:"name"`
+	Level    int    `json:"level"`
+	Size     uint   `json:"size"`
+	Inner    inner  `json:"inner"`
+	Pointer  *inner `json:"pointer,omitempty"`
+	Ignored  int    `json:"-"`
+	Untagged float64
+	private  int
+}
+
+func TestGet(t *testing.T) {
+	count := 3
+	source := outer{Name: "hvac", Level: 2, Inner: inner{Value: 1.5, Count: &count}, Untagged: 4}
+	tests := []struct {
+		path  string
+		value interface{}
+		ok    bool
+	}{
+		{path: "name", value: "hvac", ok: true},
+		{path: "level", value: int64(2), ok: true},
+		{path: "inner.value", value: float64(1.5), ok: true},
+		{path: "inner.count", value: int64(3), ok: true},
+		{path: "Untagged", value: float64(4), ok: true},
+		{path: "inner.missing"},
+		{path: "level.value"},
+		{path: "pointer.value"},
+		{path: "Ignored"},
+		{path: "private"},
+	}
+	for _, test := range tests {
+		v, ok := Get(source, test.path)
+		if ok != test.ok {
+			t.Errorf("%v: got %v, expected %v", test.path, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var value interface{}
+		switch v.Kind() {
+		case reflect.String:
+			value = v.String()
+		case reflect.Int:
+			value = v.Int()
+		default:
+			value = v.Float()
+		}
+		if value != test.value {
+			t.Errorf("%v: got %v, expected %v", test.path, value, test.value)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected float64
+		ok       bool
+	}{
+		{value: 12, expected: 12, ok: true},
+		{value: int8(-3), expected: -3, ok: true},
+		{value: uint16(7), expected: 7, ok: true},
+		{value: float32(0.5), expected: 0.5, ok: true},
+		{value: 2.25, expected: 2.25, ok: true},
+		{value: true, expected: 1, ok: true},
+		{value: false, expected: 0, ok: true},
+		{value: "12"},
+		{value: []int{1}},
+	}
+	for _, test := range tests {
+		value, ok := Number(reflect.ValueOf(test.value))
+		if ok != test.ok || value != test.expected {
+			t.Errorf("%v: got %v %v, expected %v %v", test.value, value, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		path     string
+		value    float64
+		ok       bool
+		expected outer
+	}{
+		{path: "level", value: 45.5, ok: true, expected: outer{Level: 45}},
+		{path: "level", value: 45.9, ok: true, expected: outer{Level: 45}},
+		{path: "level", value: -1.5, ok: true, expected: outer{Level: -1}},
+		{path: "size", value: 1.99, ok: true, expected: outer{Size: 1}},
+		{path: "inner.value", value: 21.5, ok: true, expected: outer{Inner: inner{Value: 21.5}}},
+		{path: "inner.enabled", value: 1, ok: true, expected: outer{Inner: inner{Enabled: true}}},
+		{path: "pointer.enabled", value: 0, ok: true, expected: outer{Pointer: &inner{}}},
+		{path: "name", value: 1},
+		{path: "inner.missing", value: 1},
+		{path: "level.value", value: 1},
+	}
+	for _, test := range tests {
+		target := outer{}
+		ok := Set(&target, test.path, test.value)
+		if ok != test.ok {
+			t.Errorf("%v: got %v, expected %v", test.path, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(target, test.expected) {
+			t.Errorf("%v: got %+v, expected %+v", test.path, target, test.expected)
+		}
+	}
+}
+
+func TestSetCount(t *testing.T) {
+	target := outer{}
+	if !Set(&target, "pointer.count", 4.7) {
+		t.Fatal("pointer.count not set")
+	}
+	if target.Pointer == nil || target.Pointer.Count == nil || *target.Pointer.Count != 4 {
+		t.Errorf("got %+v, expected an allocated count of 4", target.Pointer)
+	}
+}
+
+func TestFlattenAssign(t *testing.T) {
+	count := 3
+	source := outer{Name: "hvac", Level: 2, Size: 5, Inner: inner{Value: 1.5, Count: &count, Enabled: true}, Ignored: 8, Untagged: 4}
+	values := Flatten("conf", source)
+	expected := map[string]float64{
+		"conf.level":         2,
+		"conf.size":          5,
+		"conf.inner.value":   1.5,
+		"conf.inner.count":   3,
+		"conf.inner.enabled": 1,
+		"conf.Untagged":      4,
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("got %v, expected %v", values, expected)
+	}
+
+	values["other.level"] = 9
+	target := outer{}
+	Assign(values, "conf", &target)
+	source.Name = ""
+	source.Ignored = 0
+	if !reflect.DeepEqual(target, source) {
+		t.Errorf("got %+v, expected %+v", target, source)
+	}
+}
@@ -0,0 +1,186 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Modbus function codes
+const (
+	fcReadHolding   = 3
+	fcReadInput     = 4
+	fcWriteRegister = 6
+
+	protocolID = 0
+	maxADU     = 260
+)
+
+//Client Modbus TCP client
+//It keeps one connection open between Connect and Close
+type Client struct {
+	sync.Mutex
+	address     string
+	unitID      byte
+	timeout     time.Duration
+	conn        net.Conn
+	transaction uint16
+}
+
+type modbusError struct {
+	s string
+}
+
+func (e *modbusError) Error() string {
+	return e.s
+}
+
+// NewError raise an error
+func NewError(text string) error {
+	return &modbusError{text}
+}
+
+//NewClient create a client, the connection is opened by Connect
+func NewClient(IP string, port int, unitID byte, timeout time.Duration) *Client {
+	return &Client{
+		address: net.JoinHostPort(IP, strconv.Itoa(port)),
+		unitID:  unitID,
+		timeout: timeout,
+	}
+}
+
+//Connect open the TCP connection
+func (c *Client) Connect() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+//Close close the TCP connection
+func (c *Client) Close() {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+//ReadHoldingRegisters read quantity holding registers
+func (c *Client) ReadHoldingRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(fcReadHolding, address, quantity)
+}
+
+//ReadInputRegisters read quantity input registers
+func (c *Client) ReadInputRegisters(address uint16, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(fcReadInput, address, quantity)
+}
+
+//WriteSingleRegister write a holding register
+func (c *Client) WriteSingleRegister(address uint16, value uint16) error {
+	request := make([]byte, 4)
+	binary.BigEndian.PutUint16(request, address)
+	binary.BigEndian.PutUint16(request[2:], value)
+	response, err := c.send(fcWriteRegister, request)
+	if err != nil {
+		return err
+	}
+	if len(response) != 4 || binary.BigEndian.Uint16(response) != address {
+		return NewError("Invalid write response for register " + strconv.Itoa(int(address)))
+	}
+	return nil
+}
+
+func (c *Client) readRegisters(function byte, address uint16, quantity uint16) ([]uint16, error) {
+	if quantity == 0 || quantity > 125 {
+		return nil, NewError("Invalid register quantity " + strconv.Itoa(int(quantity)))
+	}
+	request := make([]byte, 4)
+	binary.BigEndian.PutUint16(request, address)
+	binary.BigEndian.PutUint16(request[2:], quantity)
+	response, err := c.send(function, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 1 || int(response[0]) != 2*int(quantity) || len(response) != 1+2*int(quantity) {
+		return nil, NewError("Invalid read response for register " + strconv.Itoa(int(address)))
+	}
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(response[1+2*i:])
+	}
+	return values, nil
+}
+
+//send write the request frame (MBAP header + PDU) and return the response data
+func (c *Client) send(function byte, data []byte) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		return nil, NewError("Not connected to " + c.address)
+	}
+	c.transaction++
+	frame := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(frame, c.transaction)
+	binary.BigEndian.PutUint16(frame[2:], protocolID)
+	binary.BigEndian.PutUint16(frame[4:], uint16(2+len(data)))
+	frame[6] = c.unitID
+	frame[7] = function
+	frame = append(frame, data...)
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(frame)
+	if err != nil {
+		c.reset()
+		return nil, err
+	}
+
+	header := make([]byte, 7)
+	_, err = io.ReadFull(c.conn, header)
+	if err != nil {
+		c.reset()
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 || length > maxADU-6 {
+		c.reset()
+		return nil, NewError("Invalid response length " + strconv.Itoa(length))
+	}
+	pdu := make([]byte, length-1)
+	_, err = io.ReadFull(c.conn, pdu)
+	if err != nil {
+		c.reset()
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(header) != c.transaction {
+		c.reset()
+		return nil, NewError("Unexpected transaction identifier")
+	}
+	if pdu[0] == function|0x80 {
+		if len(pdu) < 2 {
+			return nil, NewError("Modbus exception")
+		}
+		return nil, NewError("Modbus exception " + strconv.Itoa(int(pdu[1])))
+	}
+	if pdu[0] != function {
+		return nil, NewError("Unexpected function code " + strconv.Itoa(int(pdu[0])))
+	}
+	return pdu[1:], nil
+}
+
+//reset drop a connection left in an unknown state
+func (c *Client) reset() {
+	c.conn.Close()
+	c.conn = nil
+}
@@ -0,0 +1,74 @@
+package modbus
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+//ReadPoint read a register and return its scaled value
+func (c *Client) ReadPoint(register core.ModbusRegister) (float64, error) {
+	var values []uint16
+	var err error
+	if register.Type == core.RegisterInput {
+		values, err = c.ReadInputRegisters(register.Address, 1)
+	} else {
+		values, err = c.ReadHoldingRegisters(register.Address, 1)
+	}
+	if err != nil {
+		return 0, err
+	}
+	raw := float64(values[0])
+	if register.Signed {
+		raw = float64(int16(values[0]))
+	}
+	return raw * scale(register), nil
+}
+
+//WritePoint write a scaled value into a holding register
+func (c *Client) WritePoint(register core.ModbusRegister, value float64) error {
+	if register.Type == core.RegisterInput {
+		return NewError("Input register " + strconv.Itoa(int(register.Address)) + " is read only")
+	}
+	raw := math.Round(value / scale(register))
+	if register.Signed {
+		return c.WriteSingleRegister(register.Address, uint16(int16(raw)))
+	}
+	return c.WriteSingleRegister(register.Address, uint16(raw))
+}
+
+//ReadPoints read every point of the register map
+func (c *Client) ReadPoints(points map[string]core.ModbusRegister) (map[string]float64, error) {
+	values := make(map[string]float64)
+	for name, register := range points {
+		value, err := c.ReadPoint(register)
+		if err != nil {
+			return nil, NewError("Cannot read " + name + ": " + err.Error())
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+//WritePoints write the values whose point is known, the others are ignored
+func (c *Client) WritePoints(points map[string]core.ModbusRegister, values map[string]float64) error {
+	for name, value := range values {
+		register, ok := points[name]
+		if !ok {
+			continue
+		}
+		err := c.WritePoint(register, value)
+		if err != nil {
+			return NewError("Cannot write " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func scale(register core.ModbusRegister) float64 {
+	if register.Scale == 0 {
+		return 1
+	}
+	return register.Scale
+}
@@ -1,60 +1,222 @@
 package network
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/energieip/common-components-go/pkg/pconst"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
 
 	"github.com/energieip/common-components-go/pkg/dhvac"
 
-	genericNetwork "github.com/energieip/common-components-go/pkg/network"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	pkg "github.com/energieip/common-components-go/pkg/service"
 	"github.com/romana/rlog"
 )
 
+//Message classes used to select the delivery policy
+const (
+	MsgHello    = "hello"
+	MsgStatus   = "status"
+	MsgAck      = "ack"
+	MsgEvents   = "events"
+	MsgCommands = "commands"
+
+	UrlFirmware   = "firmware"
+	UrlLoop       = "loop"
+	UrlSchedule   = "schedule"
+	UrlGroup      = "group"
+	UrlFallback   = "fallback"
+	UrlInjection  = "injection"
+	UrlOccupancy  = "occupancy"
+	UrlWindow     = "window"
+	UrlChangeover = "changeover"
+	UrlOutside    = "outside/temperature"
+	UrlDemand     = "demand"
+
+	UrlCondensation = "condensation"
+	UrlAlarm        = "alarm"
+	UrlAirQuality   = "airQuality"
+
+	publishTimeout = 5 * time.Second
+)
+
 //ServerNetwork network object
 type ServerNetwork struct {
-	Iface       genericNetwork.NetworkInterface
-	EventsSetup chan map[string]dhvac.HvacSetup
-	EventsConf  chan map[string]dhvac.HvacConf
+	client         mqtt.Client
+	outbox         *outbox
+	conf           core.MqttConfig
+	prefix         string
+	EventsSetup    chan map[string]dhvac.HvacSetup
+	EventsTiming   chan core.HvacTiming
+	EventsConf     chan map[string]dhvac.HvacConf
+	EventsFirmware chan core.FirmwareRequest
+	EventsLoop     chan core.HvacLoopConf
+	EventsGroup    chan core.GroupCommand
+	EventsOutside  chan core.OutsideTemperature
+	EventsDemand   chan core.DemandRequest
+	EventsAlarmAck chan core.AlarmAck
 }
 
 //CreateServerNetwork create network server object
-func CreateServerNetwork() (*ServerNetwork, error) {
-	serverBroker, err := genericNetwork.NewNetwork(genericNetwork.MQTT)
-	if err != nil {
-		return nil, err
-	}
+func CreateServerNetwork(clientID string, conf pkg.ServiceConfig, mqttConf core.MqttConfig) (*ServerNetwork, error) {
 	serverNet := ServerNetwork{
-		Iface:       serverBroker,
-		EventsSetup: make(chan map[string]dhvac.HvacSetup),
-		EventsConf:  make(chan map[string]dhvac.HvacConf),
+		conf:           mqttConf,
+		prefix:         strings.TrimSuffix(mqttConf.TopicPrefix, "/"),
+		outbox:         newOutbox(mqttConf.BufferSize),
+		EventsSetup:    make(chan map[string]dhvac.HvacSetup),
+		EventsTiming:   make(chan core.HvacTiming),
+		EventsConf:     make(chan map[string]dhvac.HvacConf),
+		EventsFirmware: make(chan core.FirmwareRequest),
+		EventsLoop:     make(chan core.HvacLoopConf),
+		EventsGroup:    make(chan core.GroupCommand),
+		EventsOutside:  make(chan core.OutsideTemperature),
+		EventsDemand:   make(chan core.DemandRequest),
+		EventsAlarmAck: make(chan core.AlarmAck),
 	}
+
+	opts := mqtt.NewClientOptions()
+	scheme := "tcp://"
+	if conf.LocalBroker.Secure {
+		scheme = "ssl://"
+		tlsConf, err := tlsConfig(conf.LocalBroker.CaPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConf)
+	}
+	opts.AddBroker(scheme + conf.LocalBroker.IP + ":" + conf.LocalBroker.Port)
+	opts.SetClientID(clientID)
+	opts.SetUsername(conf.LocalBroker.Login)
+	opts.SetPassword(conf.LocalBroker.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(serverNet.onConnect)
+	opts.SetConnectionLostHandler(serverNet.onConnectionLost)
+	serverNet.client = mqtt.NewClient(opts)
 	return &serverNet, nil
+}
 
+func (net *ServerNetwork) delivery(msgType string) core.MqttDelivery {
+	switch msgType {
+	case MsgHello:
+		return net.conf.Hello
+	case MsgStatus:
+		return net.conf.Status
+	case MsgAck:
+		return net.conf.Ack
+	case MsgEvents:
+		return net.conf.Events
+	}
+	return net.conf.Commands
 }
 
-//Connect service to server broker
-func (net ServerNetwork) Connect(conf pkg.ServiceConfig) error {
-	cbkServer := make(map[string]func(genericNetwork.Client, genericNetwork.Message))
+//Topic return the topic prefixed by the configured topic root
+func (net *ServerNetwork) Topic(topic string) string {
+	return net.prefix + topic
+}
+
+func (net *ServerNetwork) callbacks() map[string]mqtt.MessageHandler {
+	cbkServer := make(map[string]mqtt.MessageHandler)
 	cbkServer["/write/hvac/+/"+pconst.UrlSetting] = net.onUpdateConf
 	cbkServer["/write/hvac/+/"+pconst.UrlSetup] = net.onSetup
+	cbkServer["/write/hvac/+/"+UrlFirmware] = net.onFirmware
+	cbkServer["/write/hvac/+/"+UrlLoop] = net.onLoopConf
+	cbkServer["/write/hvac/+/"+UrlAlarm] = net.onAlarmAck
+	cbkServer["/write/hvac/"+UrlGroup+"/+/"+pconst.UrlSetting] = net.onGroupConf
+	cbkServer["/write/hvac/"+UrlOutside] = net.onOutside
+	cbkServer["/write/hvac/"+UrlDemand] = net.onDemand
+	return cbkServer
+}
+
+func (net *ServerNetwork) onConnect(client mqtt.Client) {
+	qos := net.delivery(MsgCommands).Qos
+	for topic, cbk := range net.callbacks() {
+		token := client.Subscribe(net.Topic(topic), qos, cbk)
+		token.Wait()
+		if token.Error() != nil {
+			rlog.Error("Cannot subscribe to " + net.Topic(topic) + " error: " + token.Error().Error())
+			continue
+		}
+		rlog.Info("Subscribed to " + net.Topic(topic))
+	}
+	go net.drain()
+}
 
-	confServer := genericNetwork.NetworkConfig{
-		IP:        conf.LocalBroker.IP,
-		Port:      conf.LocalBroker.Port,
-		Callbacks: cbkServer,
-		LogLevel:  conf.LogLevel,
-		User:      conf.LocalBroker.Login,
-		Password:  conf.LocalBroker.Password,
-		CaPath:    conf.LocalBroker.CaPath,
-		Secure:    conf.LocalBroker.Secure,
+func (net *ServerNetwork) onConnectionLost(client mqtt.Client, err error) {
+	rlog.Error("Connection to server broker lost, buffering messages: " + err.Error())
+}
+
+//drain replay the buffered messages in order
+func (net *ServerNetwork) drain() {
+	for {
+		if !net.outbox.startDrain() {
+			return
+		}
+		ok := net.replay()
+		net.outbox.stopDrain()
+		stats := net.outbox.getStats()
+		rlog.Infof("Buffered messages replayed: %v, dropped: %v, pending: %v", stats.Replayed, stats.Dropped, stats.Pending)
+		if !ok || net.outbox.empty() {
+			return
+		}
 	}
+}
 
+func (net *ServerNetwork) replay() bool {
+	for {
+		msg, ok := net.outbox.front()
+		if !ok {
+			return true
+		}
+		err := net.publish(msg.msgType, msg.topic, msg.content)
+		if err != nil {
+			return false
+		}
+		net.outbox.pop(msg)
+	}
+}
+
+func (net *ServerNetwork) publish(msgType, topic, content string) error {
+	delivery := net.delivery(msgType)
+	token := net.client.Publish(net.Topic(topic), delivery.Qos, delivery.Retain, content)
+	if !token.WaitTimeout(publishTimeout) {
+		return NewError("Publish timeout")
+	}
+	return token.Error()
+}
+
+//BufferStats return the offline buffer metrics
+func (net *ServerNetwork) BufferStats() core.BufferStats {
+	return net.outbox.getStats()
+}
+
+func tlsConfig(caPath string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caPath == "" {
+		return config, nil
+	}
+	ca, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	config.RootCAs = pool
+	return config, nil
+}
+
+//Connect service to server broker
+func (net *ServerNetwork) Connect(conf pkg.ServiceConfig) error {
 	for {
 		rlog.Info("Try to connect to " + conf.LocalBroker.IP)
-		err := net.Iface.Initialize(confServer)
+		token := net.client.Connect()
+		token.Wait()
+		err := token.Error()
 		if err == nil {
 			rlog.Info("Connected to server broker " + conf.LocalBroker.IP)
 			return err
@@ -70,7 +232,7 @@ func (net ServerNetwork) Connect(conf pkg.ServiceConfig) error {
 	}
 }
 
-func (net ServerNetwork) onUpdateConf(client genericNetwork.Client, msg genericNetwork.Message) {
+func (net *ServerNetwork) onUpdateConf(client mqtt.Client, msg mqtt.Message) {
 	payload := msg.Payload()
 	rlog.Info(msg.Topic() + " : " + string(payload))
 	var conf dhvac.HvacConf
@@ -84,7 +246,7 @@ func (net ServerNetwork) onUpdateConf(client genericNetwork.Client, msg genericN
 	net.EventsConf <- event
 }
 
-func (net ServerNetwork) onSetup(client genericNetwork.Client, msg genericNetwork.Message) {
+func (net *ServerNetwork) onSetup(client mqtt.Client, msg mqtt.Message) {
 	payload := msg.Payload()
 	rlog.Info(msg.Topic() + " : " + string(payload))
 	var setup dhvac.HvacSetup
@@ -96,20 +258,160 @@ func (net ServerNetwork) onSetup(client genericNetwork.Client, msg genericNetwor
 	event := make(map[string]dhvac.HvacSetup)
 	event[setup.Mac] = setup
 	net.EventsSetup <- event
+
+	// the refresh period is not part of the dhvac setup
+	var timing core.HvacTiming
+	if json.Unmarshal(payload, &timing) == nil && timing.RefreshFrequency > 0 {
+		net.EventsTiming <- core.HvacTiming{
+			Mac:              setup.Mac,
+			RefreshFrequency: timing.RefreshFrequency,
+		}
+	}
+}
+
+func (net *ServerNetwork) onFirmware(client mqtt.Client, msg mqtt.Message) {
+	payload := msg.Payload()
+	rlog.Info(msg.Topic() + " : " + string(payload))
+	var request core.FirmwareRequest
+	if len(payload) > 0 {
+		// the body is optional, the configured target is used without it
+		err := json.Unmarshal(payload, &request)
+		if err != nil {
+			rlog.Error("Cannot parse firmware request ", err.Error())
+			return
+		}
+	}
+	if request.Mac == "" {
+		request.Mac = net.topicMac(msg.Topic())
+	}
+	net.EventsFirmware <- request
+}
+
+func (net *ServerNetwork) onLoopConf(client mqtt.Client, msg mqtt.Message) {
+	payload := msg.Payload()
+	rlog.Info(msg.Topic() + " : " + string(payload))
+	var conf core.HvacLoopConf
+	err := json.Unmarshal(payload, &conf)
+	if err != nil {
+		rlog.Error("Cannot parse loop config ", err.Error())
+		return
+	}
+	if conf.Mac == "" {
+		conf.Mac = net.topicMac(msg.Topic())
+	}
+	net.EventsLoop <- conf
+}
+
+func (net *ServerNetwork) onGroupConf(client mqtt.Client, msg mqtt.Message) {
+	payload := msg.Payload()
+	rlog.Info(msg.Topic() + " : " + string(payload))
+	elts := strings.Split(strings.TrimPrefix(msg.Topic(), net.prefix), "/")
+	if len(elts) < 5 {
+		return
+	}
+	group, err := strconv.Atoi(elts[4])
+	if err != nil {
+		rlog.Error("Invalid group in " + msg.Topic())
+		return
+	}
+	var conf dhvac.HvacConf
+	err = json.Unmarshal(payload, &conf)
+	if err != nil {
+		rlog.Error("Cannot parse group config ", err.Error())
+		return
+	}
+	net.EventsGroup <- core.GroupCommand{
+		Group: group,
+		Conf:  conf,
+	}
+}
+
+func (net *ServerNetwork) onOutside(client mqtt.Client, msg mqtt.Message) {
+	payload := msg.Payload()
+	rlog.Info(msg.Topic() + " : " + string(payload))
+	var outside core.OutsideTemperature
+	err := json.Unmarshal(payload, &outside)
+	if err != nil {
+		rlog.Error("Cannot parse outside temperature ", err.Error())
+		return
+	}
+	net.EventsOutside <- outside
+}
+
+func (net *ServerNetwork) onDemand(client mqtt.Client, msg mqtt.Message) {
+	payload := msg.Payload()
+	rlog.Info(msg.Topic() + " : " + string(payload))
+	var request core.DemandRequest
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		rlog.Error("Cannot parse demand response ", err.Error())
+		return
+	}
+	net.EventsDemand <- request
+}
+
+func (net *ServerNetwork) onAlarmAck(client mqtt.Client, msg mqtt.Message) {
+	payload := msg.Payload()
+	rlog.Info(msg.Topic() + " : " + string(payload))
+	var ack core.AlarmAck
+	err := json.Unmarshal(payload, &ack)
+	if err != nil {
+		rlog.Error("Cannot parse alarm acknowledgement ", err.Error())
+		return
+	}
+	ack.Mac = net.topicMac(msg.Topic())
+	net.EventsAlarmAck <- ack
+}
+
+//topicMac return the device mac address of a /write/hvac/{mac}/... topic
+func (net *ServerNetwork) topicMac(topic string) string {
+	elts := strings.Split(strings.TrimPrefix(topic, net.prefix), "/")
+	if len(elts) > 3 {
+		return elts[3]
+	}
+	return ""
 }
 
 //Disconnect from server
-func (net ServerNetwork) Disconnect() {
-	net.Iface.Disconnect()
+func (net *ServerNetwork) Disconnect() {
+	net.client.Disconnect(250)
 }
 
-//SendCommand to server
-func (net ServerNetwork) SendCommand(topic, content string) error {
-	err := net.Iface.SendCommand(topic, content)
+//SendCommand to server using the delivery policy of the message class
+//Messages are buffered while the broker is unreachable
+func (net *ServerNetwork) SendCommand(msgType, topic, content string) {
+	msg := outboxMessage{
+		msgType: msgType,
+		topic:   topic,
+		content: content,
+	}
+	if !net.client.IsConnectionOpen() || !net.outbox.empty() {
+		rlog.Debug("Buffer : " + content + " on: " + net.Topic(topic))
+		net.outbox.push(msg)
+		if net.client.IsConnectionOpen() {
+			go net.drain()
+		}
+		return
+	}
+	err := net.publish(msgType, topic, content)
 	if err != nil {
-		rlog.Error("Cannot send : " + content + " on: " + topic + " Error: " + err.Error())
-	} else {
-		rlog.Info("Sent : " + content + " on: " + topic)
+		// buffered, it is replayed once the broker answers again
+		rlog.Warn("Buffer : " + content + " on: " + net.Topic(topic) + " Error: " + err.Error())
+		net.outbox.push(msg)
+		return
 	}
-	return err
+	rlog.Info("Sent : " + content + " on: " + net.Topic(topic))
+}
+
+type networkError struct {
+	s string
+}
+
+func (e *networkError) Error() string {
+	return e.s
+}
+
+// NewError raise an error
+func NewError(text string) error {
+	return &networkError{text}
 }
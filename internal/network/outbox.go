@@ -0,0 +1,124 @@
+package network
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+type outboxMessage struct {
+	msgType string
+	topic   string
+	content string
+}
+
+//outbox bounded queue of messages waiting for the broker connection
+type outbox struct {
+	sync.Mutex
+	size     int
+	queue    *list.List
+	topics   map[string]*list.Element
+	draining bool
+	stats    core.BufferStats
+}
+
+func newOutbox(size int) *outbox {
+	return &outbox{
+		size:   size,
+		queue:  list.New(),
+		topics: make(map[string]*list.Element),
+	}
+}
+
+func coalesced(msgType string) bool {
+	return msgType == MsgStatus || msgType == MsgHello
+}
+
+//push queue a message, only the latest status or hello is kept per topic
+func (o *outbox) push(msg outboxMessage) {
+	o.Lock()
+	defer o.Unlock()
+	if o.size <= 0 {
+		o.stats.Dropped++
+		return
+	}
+	if coalesced(msg.msgType) {
+		if elt, ok := o.topics[msg.topic]; ok {
+			o.queue.Remove(elt)
+			o.stats.Coalesced++
+		}
+	}
+	for o.queue.Len() >= o.size {
+		o.remove(o.queue.Front())
+		o.stats.Dropped++
+	}
+	elt := o.queue.PushBack(msg)
+	if coalesced(msg.msgType) {
+		o.topics[msg.topic] = elt
+	}
+	o.stats.Queued++
+}
+
+//front return the oldest message without removing it
+func (o *outbox) front() (outboxMessage, bool) {
+	o.Lock()
+	defer o.Unlock()
+	elt := o.queue.Front()
+	if elt == nil {
+		return outboxMessage{}, false
+	}
+	return elt.Value.(outboxMessage), true
+}
+
+//pop remove the oldest message once it has been replayed
+func (o *outbox) pop(msg outboxMessage) {
+	o.Lock()
+	defer o.Unlock()
+	elt := o.queue.Front()
+	if elt == nil || elt.Value.(outboxMessage) != msg {
+		// coalesced in the meantime, the newer one is still queued
+		return
+	}
+	o.remove(elt)
+	o.stats.Replayed++
+}
+
+func (o *outbox) remove(elt *list.Element) {
+	msg := o.queue.Remove(elt).(outboxMessage)
+	if o.topics[msg.topic] == elt {
+		delete(o.topics, msg.topic)
+	}
+}
+
+func (o *outbox) empty() bool {
+	o.Lock()
+	defer o.Unlock()
+	return o.queue.Len() == 0
+}
+
+//startDrain return false when a drain is already in progress
+func (o *outbox) startDrain() bool {
+	o.Lock()
+	defer o.Unlock()
+	if o.draining {
+		return false
+	}
+	o.draining = true
+	return true
+}
+
+func (o *outbox) stopDrain() {
+	o.Lock()
+	defer o.Unlock()
+	o.draining = false
+}
+
+func (o *outbox) getStats() core.BufferStats {
+	o.Lock()
+	defer o.Unlock()
+	stats := o.stats
+	stats.Pending = o.queue.Len()
+	stats.Capacity = o.size
+	return stats
+}
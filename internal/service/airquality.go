@@ -0,0 +1,222 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//airQualityThresholds return the thresholds of a device, the hysteresis and duration left to 0
+//are the default ones
+func (s *Service) airQualityThresholds(hvac dhvac.Hvac) core.AirQualityThresholds {
+	conf := s.bridgeConf.AirQuality
+	thresholds := conf.AirQualityThresholds
+	found := false
+	for mac, t := range conf.Devices {
+		if strings.ToUpper(mac) == strings.ToUpper(hvac.Mac) {
+			thresholds = t
+			found = true
+			break
+		}
+	}
+	if !found {
+		if t, ok := conf.Groups[strconv.Itoa(hvac.Group)]; ok {
+			thresholds = t
+		}
+	}
+	if thresholds.CO2Hysteresis == 0 {
+		thresholds.CO2Hysteresis = conf.CO2Hysteresis
+	}
+	if thresholds.HygroHysteresis == 0 {
+		thresholds.HygroHysteresis = conf.HygroHysteresis
+	}
+	if thresholds.Duration == 0 {
+		thresholds.Duration = conf.Duration
+	}
+	return thresholds
+}
+
+//limitTransition switch a limit once the measure stayed beyond it, or back within it, for duration
+func limitTransition(limit core.AirQualityLimit, beyond bool, within bool, duration time.Duration, now time.Time) (core.AirQualityLimit, bool) {
+	pending := beyond
+	if limit.Exceeded {
+		pending = within
+	}
+	if !pending {
+		limit.Since = time.Time{}
+		return limit, false
+	}
+	if limit.Since.IsZero() {
+		limit.Since = now
+	}
+	if now.Sub(limit.Since) < duration {
+		return limit, false
+	}
+	limit.Exceeded = !limit.Exceeded
+	limit.Since = time.Time{}
+	limit.ChangedAt = now
+	return limit, true
+}
+
+func (s *Service) airQualityState(mac string) core.AirQualityState {
+	state := core.AirQualityState{
+		Mac:    mac,
+		Limits: make(map[string]core.AirQualityLimit),
+	}
+	if a, ok := s.airQualities.Get(mac); ok {
+		previous := a.(core.AirQualityState)
+		state.CO2 = previous.CO2
+		state.Hygrometry = previous.Hygrometry
+		state.Thresholds = previous.Thresholds
+		state.DamperBoosted = previous.DamperBoosted
+		state.DamperPrevious = previous.DamperPrevious
+		for name, limit := range previous.Limits {
+			state.Limits[name] = limit
+		}
+	}
+	return state
+}
+
+//evaluateAirQuality check the CO2 and hygrometry limits of a refreshed device
+func (s *Service) evaluateAirQuality(mac string) {
+	if !s.bridgeConf.AirQuality.Enabled {
+		return
+	}
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || !hvac.IsConfigured || hvac.Error != 0 {
+		return
+	}
+	thresholds := s.airQualityThresholds(*hvac)
+	state := s.airQualityState(mac)
+	state.CO2 = hvac.SpaceCO2
+	state.Hygrometry = hvac.SpaceHygro
+	state.Thresholds = thresholds
+
+	now := time.Now().UTC()
+	duration := time.Duration(thresholds.Duration) * time.Millisecond
+	checks := []struct {
+		name      string
+		value     int
+		threshold int
+		beyond    bool
+		within    bool
+	}{
+		{core.AirQualityCO2, hvac.SpaceCO2, thresholds.CO2Max,
+			hvac.SpaceCO2 > thresholds.CO2Max, hvac.SpaceCO2 <= thresholds.CO2Max-thresholds.CO2Hysteresis},
+		{core.AirQualityHumid, hvac.SpaceHygro, thresholds.HygroMax,
+			hvac.SpaceHygro > thresholds.HygroMax, hvac.SpaceHygro <= thresholds.HygroMax-thresholds.HygroHysteresis},
+		{core.AirQualityDry, hvac.SpaceHygro, thresholds.HygroMin,
+			hvac.SpaceHygro < thresholds.HygroMin, hvac.SpaceHygro >= thresholds.HygroMin+thresholds.HygroHysteresis},
+	}
+	for _, check := range checks {
+		wait := duration
+		if check.threshold == 0 {
+			// disabled, a limit exceeded before is back to normal
+			check.beyond = false
+			check.within = true
+			wait = 0
+		}
+		limit, changed := limitTransition(state.Limits[check.name], check.beyond, check.within, wait, now)
+		state.Limits[check.name] = limit
+		if !changed {
+			continue
+		}
+		event := core.AirQualityEvent{
+			Mac:       mac,
+			Limit:     check.name,
+			Exceeded:  limit.Exceeded,
+			Value:     check.value,
+			Threshold: check.threshold,
+			Date:      now,
+		}
+		rlog.Infof("Air quality %v of %v exceeded %v: %v for %v", check.name, mac, limit.Exceeded, check.value, check.threshold)
+		s.sendAirQuality(event)
+		if check.name == core.AirQualityCO2 {
+			s.setAlarm(mac, core.AlarmCO2, limit.Exceeded, "CO2 "+strconv.Itoa(check.value)+" ppm above "+strconv.Itoa(check.threshold))
+		}
+	}
+	s.boostDamper(*hvac, &state)
+	humid := state.Limits[core.AirQualityHumid].Exceeded
+	dry := state.Limits[core.AirQualityDry].Exceeded
+	s.setAlarm(mac, core.AlarmHygrometry, humid || dry, "Hygrometry "+strconv.Itoa(hvac.SpaceHygro)+" out of limits")
+	s.airQualities.Set(mac, state)
+}
+
+//boostDamper open the fresh air damper while the CO2 limit is exceeded and set back the minimum
+//opening read from the device before, a failed write is retried at the next refresh
+func (s *Service) boostDamper(hvac dhvac.Hvac, state *core.AirQualityState) {
+	conf := s.bridgeConf.AirQuality
+	exceeded := state.Limits[core.AirQualityCO2].Exceeded
+	if conf.DamperBoost == nil || exceeded == state.DamperBoosted {
+		return
+	}
+	driver := s.deviceDriver(hvac.Mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot get token info from " + hvac.Mac)
+		return
+	}
+	opening := conf.DamperNormal
+	if exceeded {
+		if state.DamperPrevious == nil {
+			setup, err := driver.ReadAirRegister(hvac.IP, token)
+			if err != nil {
+				rlog.Error("Cannot read fresh air damper of " + hvac.Mac + ": " + err.Error())
+				return
+			}
+			previous := conf.DamperNormal
+			if setup.OADamperMin != nil {
+				previous = *setup.OADamperMin
+			}
+			state.DamperPrevious = &previous
+		}
+		opening = *conf.DamperBoost
+	} else if state.DamperPrevious != nil {
+		opening = *state.DamperPrevious
+	}
+	err = driver.ApplyAirRegister(hvac.Mac, core.HvacSetupAirQualityCtrl{
+		OADamperMin: &opening,
+	}, hvac.IP, token)
+	if err != nil {
+		rlog.Error("Cannot set fresh air damper of " + hvac.Mac + ": " + err.Error())
+		return
+	}
+	rlog.Infof("Fresh air damper minimum of %v set to %v%%", hvac.Mac, opening)
+	state.DamperBoosted = exceeded
+	if !exceeded {
+		state.DamperPrevious = nil
+	}
+}
+
+func (s *Service) sendAirQuality(event core.AirQualityEvent) {
+	dump, err := tools.ToJSON(event)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v air quality %v", event.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+event.Mac+"/"+net.UrlAirQuality, dump)
+}
+
+//DeviceAirQuality return the air quality limits of a device
+func (s *Service) DeviceAirQuality(mac string) (*core.AirQualityState, bool) {
+	mac = strings.ToUpper(mac)
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return nil, false
+	}
+	state := s.airQualityState(mac)
+	if hvac, err := dhvac.ToHvac(d); err == nil {
+		state.Thresholds = s.airQualityThresholds(*hvac)
+	}
+	return &state, true
+}
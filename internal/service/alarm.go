@@ -0,0 +1,285 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//alarmManager alarms by device and type and the history of their transitions
+//An alarm is forgotten once cleared and acknowledged, the history keeps the last size transitions
+type alarmManager struct {
+	sync.Mutex
+	size    int
+	alarms  map[string]*core.Alarm
+	history []core.AlarmEvent
+}
+
+func newAlarmManager(size int) *alarmManager {
+	return &alarmManager{
+		size:    size,
+		alarms:  make(map[string]*core.Alarm),
+		history: []core.AlarmEvent{},
+	}
+}
+
+func alarmKey(mac string, alarmType string) string {
+	return mac + "/" + alarmType
+}
+
+//record append a transition to the history, the lock must be held
+func (m *alarmManager) record(transition string, alarm core.Alarm) core.AlarmEvent {
+	event := core.AlarmEvent{
+		Transition: transition,
+		Date:       time.Now().UTC(),
+		Alarm:      alarm,
+	}
+	if m.size <= 0 {
+		return event
+	}
+	m.history = append(m.history, event)
+	if len(m.history) > m.size {
+		m.history = append([]core.AlarmEvent{}, m.history[len(m.history)-m.size:]...)
+	}
+	return event
+}
+
+//setAlarm raise or clear an alarm of a device, only the transitions are recorded and published
+func (s *Service) setAlarm(mac string, alarmType string, active bool, message string) {
+	mac = strings.ToUpper(mac)
+	key := alarmKey(mac, alarmType)
+	m := s.alarms
+	m.Lock()
+	alarm, ok := m.alarms[key]
+	if active == (ok && alarm.Active) {
+		m.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	transition := core.AlarmRaised
+	if active {
+		severity, ok := s.bridgeConf.Alarms.Severities[alarmType]
+		if !ok {
+			severity = core.SeverityMinor
+		}
+		alarm = &core.Alarm{
+			Mac:      mac,
+			Type:     alarmType,
+			Severity: severity,
+			Message:  message,
+			Active:   true,
+			RaisedAt: now,
+		}
+		m.alarms[key] = alarm
+	} else {
+		transition = core.AlarmCleared
+		alarm.Active = false
+		alarm.ClearedAt = now
+		if alarm.Acknowledged {
+			delete(m.alarms, key)
+		}
+	}
+	event := m.record(transition, *alarm)
+	m.Unlock()
+
+	rlog.Infof("Alarm %v %v on %v: %v", alarmType, transition, mac, alarm.Message)
+	s.sendAlarm(event)
+}
+
+func (s *Service) sendAlarm(event core.AlarmEvent) {
+	dump, err := tools.ToJSON(event)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v alarm %v", event.Alarm.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+event.Alarm.Mac+"/"+net.UrlAlarm, dump)
+}
+
+//trackSetpoints record the setpoints set by the server on a device
+func (s *Service) trackSetpoints(conf dhvac.HvacConf) {
+	mac := strings.ToUpper(conf.Mac)
+	commanded := core.ScheduleAction{}
+	if c, ok := s.commanded.Get(mac); ok {
+		commanded = c.(core.ScheduleAction)
+	}
+	set := func(dst **int, v *int) {
+		if v != nil {
+			value := *v
+			*dst = &value
+		}
+	}
+	set(&commanded.SetpointCoolOccupied, conf.SetpointCoolOccupied)
+	set(&commanded.SetpointHeatOccupied, conf.SetpointHeatOccupied)
+	set(&commanded.SetpointCoolInoccupied, conf.SetpointCoolInoccupied)
+	set(&commanded.SetpointHeatInoccupied, conf.SetpointHeatInoccupied)
+	set(&commanded.SetpointCoolStandby, conf.SetpointCoolStandby)
+	set(&commanded.SetpointHeatStandby, conf.SetpointHeatStandby)
+	if !emptyAction(commanded) {
+		s.commanded.Set(mac, commanded)
+	}
+}
+
+//setpointDrift compare the device setpoints with the ones set by the server
+//evaluated is false while the window protection or the fallback replace them
+func (s *Service) setpointDrift(hvac dhvac.Hvac) (drift bool, message string, evaluated bool) {
+	mac := strings.ToUpper(hvac.Mac)
+	c, ok := s.commanded.Get(mac)
+	if !ok {
+		return false, "", true
+	}
+	if s.windowProtected(mac) || s.fallbackActive(mac) {
+		return false, "", false
+	}
+	commanded := c.(core.ScheduleAction)
+	current := currentAction(hvac, commanded)
+	setpoints := []struct {
+		name     string
+		expected *int
+		value    *int
+	}{
+		{"cool occupied", commanded.SetpointCoolOccupied, current.SetpointCoolOccupied},
+		{"heat occupied", commanded.SetpointHeatOccupied, current.SetpointHeatOccupied},
+		{"cool unoccupied", commanded.SetpointCoolInoccupied, current.SetpointCoolInoccupied},
+		{"heat unoccupied", commanded.SetpointHeatInoccupied, current.SetpointHeatInoccupied},
+		{"cool standby", commanded.SetpointCoolStandby, current.SetpointCoolStandby},
+		{"heat standby", commanded.SetpointHeatStandby, current.SetpointHeatStandby},
+	}
+	for _, setpoint := range setpoints {
+		if setpoint.expected == nil {
+			continue
+		}
+		diff := *setpoint.value - *setpoint.expected
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > s.bridgeConf.Alarms.DriftTolerance {
+			return true, "Setpoint " + setpoint.name + " " + strconv.Itoa(*setpoint.value) + " instead of " + strconv.Itoa(*setpoint.expected), true
+		}
+	}
+	return false, "", true
+}
+
+//firmwareMismatch return true when a device runs another version than its target outside updates
+func (s *Service) firmwareMismatch(hvac dhvac.Hvac) (bool, string) {
+	if hvac.SoftwareVersion == "" {
+		return false, ""
+	}
+	if update, ok := s.FirmwareUpdate(hvac.Mac); ok && !update.Finished() {
+		return false, ""
+	}
+	productType := ""
+	if p, ok := s.productTypes.Get(strings.ToUpper(hvac.Mac)); ok {
+		productType = p.(string)
+	}
+	target := s.targetVersion(hvac.Mac, productType)
+	return hvac.SoftwareVersion != target, "Version " + hvac.SoftwareVersion + " instead of " + target
+}
+
+//evaluateAlarms raise or clear the alarms of a refreshed device
+func (s *Service) evaluateAlarms(mac string) {
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return
+	}
+	s.setAlarm(mac, core.AlarmAuthFailure, hvac.Error == 1, "Login failed")
+	s.setAlarm(mac, core.AlarmUnreachable, hvac.Error == 2, "Status cannot be read")
+	if hvac.Error != 0 {
+		// the other values are not refreshed
+		return
+	}
+	conf := s.bridgeConf.Alarms
+	s.setAlarm(mac, core.AlarmTestMode, hvac.HeatCool1 == dhvac.HVAC_MODE_TEST, "Test mode active")
+	if !s.bridgeConf.AirQuality.Enabled {
+		// raised by the air quality limits otherwise
+		s.setAlarm(mac, core.AlarmCO2, conf.CO2Max > 0 && hvac.SpaceCO2 > conf.CO2Max,
+			"CO2 "+strconv.Itoa(hvac.SpaceCO2)+" ppm above "+strconv.Itoa(conf.CO2Max))
+	}
+	if drift, message, evaluated := s.setpointDrift(*hvac); evaluated {
+		s.setAlarm(mac, core.AlarmSetpointDrift, drift, message)
+	}
+	mismatch, message := s.firmwareMismatch(*hvac)
+	s.setAlarm(mac, core.AlarmFirmwareMismatch, mismatch, message)
+}
+
+//AcknowledgeAlarm acknowledge an alarm of a device
+func (s *Service) AcknowledgeAlarm(ack core.AlarmAck) (*core.Alarm, bool) {
+	mac := strings.ToUpper(ack.Mac)
+	key := alarmKey(mac, ack.Type)
+	m := s.alarms
+	m.Lock()
+	alarm, ok := m.alarms[key]
+	if !ok {
+		m.Unlock()
+		return nil, false
+	}
+	if alarm.Acknowledged {
+		result := *alarm
+		m.Unlock()
+		return &result, true
+	}
+	alarm.Acknowledged = true
+	alarm.AcknowledgedAt = time.Now().UTC()
+	alarm.AcknowledgedBy = ack.By
+	if !alarm.Active {
+		delete(m.alarms, key)
+	}
+	event := m.record(core.AlarmAcknowledged, *alarm)
+	result := *alarm
+	m.Unlock()
+
+	rlog.Infof("Alarm %v on %v acknowledged by %v", ack.Type, mac, ack.By)
+	s.sendAlarm(event)
+	return &result, true
+}
+
+//receivedAlarmAck acknowledge an alarm received on MQTT
+func (s *Service) receivedAlarmAck(ack core.AlarmAck) {
+	if _, ok := s.AcknowledgeAlarm(ack); !ok {
+		rlog.Warn("No alarm " + ack.Type + " on " + ack.Mac + " to acknowledge")
+	}
+}
+
+//Alarms return the alarms not yet cleared and acknowledged sorted by mac address and type
+func (s *Service) Alarms() []core.Alarm {
+	m := s.alarms
+	m.Lock()
+	defer m.Unlock()
+	alarms := []core.Alarm{}
+	for _, alarm := range m.alarms {
+		alarms = append(alarms, *alarm)
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].Mac != alarms[j].Mac {
+			return alarms[i].Mac < alarms[j].Mac
+		}
+		return alarms[i].Type < alarms[j].Type
+	})
+	return alarms
+}
+
+//AlarmHistory return the alarm transitions, oldest first, filtered by device and type when set
+func (s *Service) AlarmHistory(mac string, alarmType string) []core.AlarmEvent {
+	mac = strings.ToUpper(mac)
+	m := s.alarms
+	m.Lock()
+	defer m.Unlock()
+	events := []core.AlarmEvent{}
+	for _, event := range m.history {
+		if (mac == "" || event.Alarm.Mac == mac) && (alarmType == "" || event.Alarm.Type == alarmType) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
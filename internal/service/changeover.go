@@ -0,0 +1,198 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//changeoverManager bridge heat/cool changeover
+//running serializes the runs of applyChangeover
+type changeoverManager struct {
+	sync.Mutex
+	running sync.Mutex
+	state   core.ChangeoverState
+}
+
+func newChangeoverManager(conf core.ChangeoverConfig) *changeoverManager {
+	return &changeoverManager{
+		state: core.ChangeoverState{
+			Enabled: conf.Enabled,
+			Devices: []core.GroupDeviceResult{},
+		},
+	}
+}
+
+//changeoverMode return the mode for an outside temperature, current is kept within its hysteresis
+func changeoverMode(current *int, outside int, conf core.ChangeoverConfig) int {
+	if current != nil {
+		switch *current {
+		case dhvac.HVAC_MODE_HEAT:
+			if outside < conf.HeatBelow+conf.Hysteresis {
+				return dhvac.HVAC_MODE_HEAT
+			}
+		case dhvac.HVAC_MODE_COOL:
+			if outside > conf.CoolAbove-conf.Hysteresis {
+				return dhvac.HVAC_MODE_COOL
+			}
+		}
+	}
+	switch {
+	case outside < conf.HeatBelow:
+		return dhvac.HVAC_MODE_HEAT
+	case outside > conf.CoolAbove:
+		return dhvac.HVAC_MODE_COOL
+	}
+	return dhvac.HVAC_MODE_AUTO
+}
+
+//receivedOutside record the outside temperature and switch the devices when needed
+func (s *Service) receivedOutside(outside core.OutsideTemperature) {
+	m := s.changeover
+	m.Lock()
+	temperature := outside.Temperature
+	m.state.Outside = &temperature
+	m.state.OutsideAt = time.Now().UTC()
+	m.Unlock()
+	s.applyChangeover()
+}
+
+//applyChangeover switch the devices when the outside temperature selects another mode
+func (s *Service) applyChangeover() {
+	conf := s.bridgeConf.Changeover
+	if !conf.Enabled {
+		return
+	}
+	m := s.changeover
+	m.running.Lock()
+	defer m.running.Unlock()
+
+	now := time.Now().UTC()
+	m.Lock()
+	if m.state.Outside == nil {
+		m.Unlock()
+		return
+	}
+	stale := now.Sub(m.state.OutsideAt) > time.Duration(conf.MaxAge)*time.Millisecond
+	if stale != m.state.Stale {
+		m.state.Stale = stale
+		state := m.state
+		m.Unlock()
+		if stale {
+			rlog.Warn("Outside temperature is stale, changeover mode kept")
+		}
+		s.sendChangeover(state)
+		m.Lock()
+	}
+	if stale {
+		current := m.state.Mode
+		m.Unlock()
+		if current != nil {
+			s.retryChangeover(*current)
+		}
+		return
+	}
+	current := m.state.Mode
+	mode := changeoverMode(current, *m.state.Outside, conf)
+	if current != nil && (mode == *current || now.Sub(m.state.ChangedAt) < time.Duration(conf.MinDwell)*time.Millisecond) {
+		m.Unlock()
+		s.retryChangeover(*current)
+		return
+	}
+	outside := *m.state.Outside
+	m.Unlock()
+
+	macs := s.groupsMembers(conf.Groups)
+	rlog.Infof("Changeover to mode %v for %v devices, outside temperature %v", mode, len(macs), outside)
+	devices := s.commandDevices(macs, EventConf, func(string) dhvac.HvacConf {
+		return dhvac.HvacConf{
+			HeatCool: &mode,
+		}
+	})
+
+	m.Lock()
+	m.state.Mode = &mode
+	m.state.ChangedAt = now
+	m.state.Devices = devices
+	state := m.state
+	m.Unlock()
+	s.sendChangeover(state)
+}
+
+//retryChangeover send the current mode again to the devices which did not apply it,
+//failed or timed out ones and the ones configured since the last switch
+func (s *Service) retryChangeover(mode int) {
+	m := s.changeover
+	m.Lock()
+	applied := make(map[string]bool)
+	for _, device := range m.state.Devices {
+		applied[device.Mac] = device.Status == core.GroupDeviceSuccess
+	}
+	m.Unlock()
+
+	pending := []string{}
+	for _, mac := range s.groupsMembers(s.bridgeConf.Changeover.Groups) {
+		if !applied[mac] {
+			pending = append(pending, mac)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	rlog.Infof("Changeover mode %v sent again to %v devices", mode, len(pending))
+	devices := s.commandDevices(pending, EventConf, func(string) dhvac.HvacConf {
+		return dhvac.HvacConf{
+			HeatCool: &mode,
+		}
+	})
+
+	m.Lock()
+	index := make(map[string]int)
+	for i, device := range m.state.Devices {
+		index[device.Mac] = i
+	}
+	for _, device := range devices {
+		if i, ok := index[device.Mac]; ok {
+			m.state.Devices[i] = device
+			continue
+		}
+		m.state.Devices = append(m.state.Devices, device)
+	}
+	state := m.state
+	m.Unlock()
+	s.sendChangeover(state)
+}
+
+func (s *Service) sendChangeover(state core.ChangeoverState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump changeover %v", err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+net.UrlChangeover, dump)
+}
+
+//cronChangeover apply the changeover delayed by the minimum dwell time and send the current mode
+//to the devices which did not apply it
+func (s *Service) cronChangeover() {
+	timer := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-timer.C:
+			s.applyChangeover()
+		}
+	}
+}
+
+//Changeover return the bridge heat/cool changeover state
+func (s *Service) Changeover() core.ChangeoverState {
+	m := s.changeover
+	m.Lock()
+	defer m.Unlock()
+	return m.state
+}
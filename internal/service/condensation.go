@@ -0,0 +1,203 @@
+package service
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//dewPoint return the dew point (Magnus formula) of a temperature and a hygrometry in tenth of °C and tenth of %
+func dewPoint(temperature int, hygrometry int) (int, bool) {
+	if hygrometry <= 0 {
+		return 0, false
+	}
+	const b, c = 17.62, 243.12
+	t := float64(temperature) / 10
+	gamma := math.Log(float64(hygrometry)/1000) + b*t/(c+t)
+	return int(math.Round(10 * c * gamma / (b - gamma))), true
+}
+
+//injectedDewPoint return the dew point of the measures injected in a device, when both are fresh
+func (s *Service) injectedDewPoint(mac string) (int, bool) {
+	measures := s.injectionState(mac).Measures
+	temperature, ok := measures[core.MeasureTemperature]
+	if !ok || temperature.Stale {
+		return 0, false
+	}
+	hygrometry, ok := measures[core.MeasureHygrometry]
+	if !ok || hygrometry.Stale {
+		return 0, false
+	}
+	return dewPoint(temperature.Value, hygrometry.Value)
+}
+
+//condensationAlarm return the alarm of a device, the dew point keeps it raised within the hysteresis
+func condensationAlarm(state core.CondensationState, conf core.CondensationConfig) bool {
+	if state.DewSensor {
+		return true
+	}
+	if conf.DewPointMax == nil || state.DewPoint == nil {
+		return false
+	}
+	limit := *conf.DewPointMax
+	if state.Alarm {
+		limit -= conf.Hysteresis
+	}
+	return *state.DewPoint >= limit
+}
+
+func (s *Service) condensationState(mac string) core.CondensationState {
+	if c, ok := s.condensations.Get(mac); ok {
+		return c.(core.CondensationState)
+	}
+	return core.CondensationState{
+		Mac: mac,
+	}
+}
+
+//evaluateCondensation raise or clear the condensation alarm of a refreshed device
+func (s *Service) evaluateCondensation(mac string) {
+	conf := s.bridgeConf.Condensation
+	if !conf.Enabled {
+		return
+	}
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || !hvac.IsConfigured {
+		return
+	}
+	state := s.condensationState(mac)
+	state.DewSensor = hvac.DewSensor1 != 0
+	state.DewPoint = nil
+	if point, ok := s.injectedDewPoint(mac); ok {
+		state.DewPoint = &point
+	}
+	alarm := condensationAlarm(state, conf)
+	changed := alarm != state.Alarm
+	if changed {
+		now := time.Now().UTC()
+		state.Alarm = alarm
+		if alarm {
+			state.RaisedAt = now
+			rlog.Warnf("Condensation alarm raised on %v, dew sensor %v, dew point %v", mac, state.DewSensor, dewPointLog(state.DewPoint))
+		} else {
+			state.ClearedAt = now
+			rlog.Infof("Condensation alarm cleared on %v, dew point %v", mac, dewPointLog(state.DewPoint))
+		}
+	}
+	switched := s.condensationCooling(*hvac, &state, conf)
+	s.condensations.Set(mac, state)
+	if !changed && !switched {
+		return
+	}
+	s.sendCondensation(state)
+	if changed {
+		s.setAlarm(mac, core.AlarmCondensation, alarm, "Dew sensor "+strconv.FormatBool(state.DewSensor)+", dew point "+dewPointLog(state.DewPoint))
+	}
+}
+
+//condensationCooling switch the cooling off while the alarm is raised and restore the previous
+//heat/cool mode once cleared, a failed write is retried on the next refresh. It returns true
+//when the cooling was switched
+func (s *Service) condensationCooling(hvac dhvac.Hvac, state *core.CondensationState, conf core.CondensationConfig) bool {
+	if state.Alarm && !state.CoolingOff {
+		if !conf.CoolingOff || (hvac.HeatCool1 != dhvac.HVAC_MODE_COOL && hvac.HeatCool1 != dhvac.HVAC_MODE_AUTO) {
+			return false
+		}
+		previous := hvac.HeatCool1
+		if !s.applyHeatCool(hvac, dhvac.HVAC_MODE_OFF) {
+			return false
+		}
+		state.CoolingOff = true
+		state.Previous = &previous
+		rlog.Info("Cooling of " + hvac.Mac + " switched off")
+		return true
+	}
+	if !state.Alarm && state.CoolingOff {
+		if state.Previous != nil {
+			if !s.applyHeatCool(hvac, *state.Previous) {
+				return false
+			}
+			rlog.Info("Heat/cool mode " + strconv.Itoa(*state.Previous) + " of " + hvac.Mac + " restored")
+		}
+		state.CoolingOff = false
+		state.Previous = nil
+		return true
+	}
+	return false
+}
+
+//receivedCondensationSetting record the heat/cool mode of a setting received while the cooling
+//is switched off as the mode restored, a cooling mode is removed from the setting
+func (s *Service) receivedCondensationSetting(conf *dhvac.HvacConf) {
+	if conf.HeatCool == nil {
+		return
+	}
+	mac := strings.ToUpper(conf.Mac)
+	state := s.condensationState(mac)
+	if !state.CoolingOff {
+		return
+	}
+	mode := *conf.HeatCool
+	state.Previous = &mode
+	if mode == dhvac.HVAC_MODE_COOL || mode == dhvac.HVAC_MODE_AUTO {
+		conf.HeatCool = nil
+		rlog.Info("Heat/cool mode " + strconv.Itoa(mode) + " of " + mac + " kept until the condensation alarm clears")
+	}
+	s.condensations.Set(mac, state)
+}
+
+func dewPointLog(point *int) string {
+	if point == nil {
+		return "unknown"
+	}
+	return strconv.Itoa(*point)
+}
+
+//applyHeatCool write the heat/cool mode of a device
+func (s *Service) applyHeatCool(hvac dhvac.Hvac, mode int) bool {
+	driver := s.deviceDriver(hvac.Mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot get token info from " + hvac.Mac)
+		return false
+	}
+	err = driver.ApplyConf(dhvac.HvacConf{
+		Mac:      hvac.Mac,
+		HeatCool: &mode,
+	}, hvac, token)
+	if err != nil {
+		rlog.Error("Cannot switch heat/cool mode of " + hvac.Mac + ": " + err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Service) sendCondensation(state core.CondensationState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v condensation %v", state.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+state.Mac+"/"+net.UrlCondensation, dump)
+}
+
+//DeviceCondensation return the condensation protection state of a device
+func (s *Service) DeviceCondensation(mac string) (*core.CondensationState, bool) {
+	mac = strings.ToUpper(mac)
+	if _, ok := s.hvacs.Get(mac); !ok {
+		return nil, false
+	}
+	state := s.condensationState(mac)
+	return &state, true
+}
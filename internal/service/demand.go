@@ -0,0 +1,434 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+const demandTick = 10 * time.Second
+
+//demandManager demand response applied to the devices
+//running serializes the shedding and the restoration, level is the one of the shed devices
+//restoring holds the devices whose restoration failed, they are restored again by cronDemand
+type demandManager struct {
+	sync.Mutex
+	running   sync.Mutex
+	state     core.DemandState
+	level     core.DemandLevel
+	devices   map[string]*core.DemandDevice
+	restoring map[string]bool
+}
+
+func newDemandManager() *demandManager {
+	return &demandManager{
+		state: core.DemandState{
+			Devices: []core.DemandDevice{},
+		},
+		devices:   make(map[string]*core.DemandDevice),
+		restoring: make(map[string]bool),
+	}
+}
+
+//list return the devices sorted by mac address, the lock must be held
+func (m *demandManager) list() []core.DemandDevice {
+	devices := []core.DemandDevice{}
+	for _, device := range m.devices {
+		devices = append(devices, *device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Mac < devices[j].Mac
+	})
+	return devices
+}
+
+//shedAction widen the setpoints of previous and force the economy mode of a level
+func shedAction(previous core.ScheduleAction, level core.DemandLevel) core.ScheduleAction {
+	widen := func(v *int, offset int) *int {
+		if v == nil {
+			return nil
+		}
+		w := *v + offset
+		return &w
+	}
+	shed := core.ScheduleAction{
+		SetpointCoolOccupied:   widen(previous.SetpointCoolOccupied, level.Offset),
+		SetpointHeatOccupied:   widen(previous.SetpointHeatOccupied, -level.Offset),
+		SetpointCoolInoccupied: widen(previous.SetpointCoolInoccupied, level.Offset),
+		SetpointHeatInoccupied: widen(previous.SetpointHeatInoccupied, -level.Offset),
+		SetpointCoolStandby:    widen(previous.SetpointCoolStandby, level.Offset),
+		SetpointHeatStandby:    widen(previous.SetpointHeatStandby, -level.Offset),
+	}
+	if level.Economy {
+		economy := dhvac.OCCUPANCY_ECONOMY
+		shed.TargetMode = &economy
+	}
+	return shed
+}
+
+//demandPrevious return the values to restore, the mode is only recorded once it is forced
+func demandPrevious(hvac dhvac.Hvac, device *core.DemandDevice, level core.DemandLevel) core.ScheduleAction {
+	var previous core.ScheduleAction
+	if device != nil {
+		previous = device.Previous
+	} else {
+		set := 0
+		previous = currentAction(hvac, core.ScheduleAction{
+			SetpointCoolOccupied:   &set,
+			SetpointHeatOccupied:   &set,
+			SetpointCoolInoccupied: &set,
+			SetpointHeatInoccupied: &set,
+			SetpointCoolStandby:    &set,
+			SetpointHeatStandby:    &set,
+		})
+	}
+	if level.Economy && previous.TargetMode == nil {
+		mode := hvac.OccManCmd1
+		previous.TargetMode = &mode
+	}
+	return previous
+}
+
+//DemandResponse shed the selected devices at the requested level, the devices no longer
+//selected are restored. The level 0 restores every device
+func (s *Service) DemandResponse(request core.DemandRequest) (*core.DemandState, error) {
+	levels := s.bridgeConf.Demand.Levels
+	if request.Level < 0 || request.Level > len(levels) || request.Duration < 0 {
+		return nil, NewError("Invalid demand response level " + strconv.Itoa(request.Level))
+	}
+	m := s.demand
+	m.running.Lock()
+	defer m.running.Unlock()
+	if request.Level == 0 {
+		rlog.Info("Demand response cancelled")
+		state := s.restoreDemand()
+		return &state, nil
+	}
+	level := levels[request.Level-1]
+	now := time.Now().UTC()
+	if request.Duration > 0 {
+		request.Until = now.Add(time.Duration(request.Duration) * time.Millisecond)
+	} else {
+		request.Until = time.Time{}
+	}
+
+	macs := s.groupsMembers(request.Groups)
+	selected := make(map[string]bool)
+	for _, mac := range macs {
+		selected[mac] = true
+	}
+	m.Lock()
+	released := []string{}
+	for mac := range m.devices {
+		if !selected[mac] {
+			released = append(released, mac)
+		}
+	}
+	m.Unlock()
+	restored := s.restoreDevices(released)
+
+	rlog.Infof("Demand response level %v for %v devices", request.Level, len(macs))
+	shed := make(map[string]core.ScheduleAction)
+	m.Lock()
+	m.level = level
+	for _, mac := range macs {
+		d, ok := s.hvacs.Get(mac)
+		if !ok {
+			continue
+		}
+		hvac, err := dhvac.ToHvac(d)
+		if err != nil {
+			continue
+		}
+		previous := demandPrevious(*hvac, m.devices[mac], level)
+		delete(m.restoring, mac)
+		m.devices[mac] = &core.DemandDevice{
+			Mac:      mac,
+			Previous: previous,
+		}
+		shed[mac] = shedAction(previous, level)
+	}
+	m.Unlock()
+
+	results := s.commandDevices(macs, EventDemand, func(mac string) dhvac.HvacConf {
+		return scheduleConf(mac, shed[mac])
+	})
+
+	m.Lock()
+	for _, result := range results {
+		device, ok := m.devices[result.Mac]
+		if !ok {
+			continue
+		}
+		device.Status = core.DemandShed
+		device.Message = result.Message
+		if result.Status != core.GroupDeviceSuccess {
+			device.Status = core.DemandFailed
+			rlog.Warn("Demand response not applied to " + result.Mac + " " + result.Status + " " + result.Message)
+		}
+	}
+	if !m.state.Active {
+		m.state.StartedAt = now
+	}
+	m.state.Active = true
+	m.state.Request = &request
+	m.state.EndedAt = time.Time{}
+	m.state.Devices = mergeDemandDevices(m.list(), restored)
+	state := m.state
+	m.Unlock()
+	s.sendDemand(state)
+	return &state, nil
+}
+
+//mergeDemandDevices return devices with the results of the devices they do not hold, sorted by
+//mac address
+func mergeDemandDevices(devices []core.DemandDevice, results []core.DemandDevice) []core.DemandDevice {
+	known := make(map[string]bool)
+	for _, device := range devices {
+		known[device.Mac] = true
+	}
+	for _, result := range results {
+		if !known[result.Mac] {
+			devices = append(devices, result)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Mac < devices[j].Mac
+	})
+	return devices
+}
+
+//restoreDevices send back the previous values of devices and forget them, the devices whose
+//restoration failed are kept to be restored again
+func (s *Service) restoreDevices(macs []string) []core.DemandDevice {
+	if len(macs) == 0 {
+		return []core.DemandDevice{}
+	}
+	m := s.demand
+	m.Lock()
+	previous := make(map[string]core.ScheduleAction)
+	for _, mac := range macs {
+		previous[mac] = m.devices[mac].Previous
+	}
+	m.Unlock()
+
+	results := s.commandDevices(macs, EventDemand, func(mac string) dhvac.HvacConf {
+		return scheduleConf(mac, previous[mac])
+	})
+
+	m.Lock()
+	defer m.Unlock()
+	devices := []core.DemandDevice{}
+	for _, result := range results {
+		kept, ok := m.devices[result.Mac]
+		if !ok {
+			continue
+		}
+		device := *kept
+		device.Status = core.DemandRestored
+		device.Message = result.Message
+		if result.Status != core.GroupDeviceSuccess {
+			device.Status = core.DemandFailed
+			*kept = device
+			m.restoring[result.Mac] = true
+			rlog.Error("Demand response not restored on " + result.Mac + " " + result.Status + " " + result.Message)
+		} else {
+			delete(m.devices, result.Mac)
+			delete(m.restoring, result.Mac)
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+//restoreDemand end the demand response, m.running must be held
+func (s *Service) restoreDemand() core.DemandState {
+	m := s.demand
+	m.Lock()
+	macs := []string{}
+	for mac := range m.devices {
+		macs = append(macs, mac)
+	}
+	m.Unlock()
+	sort.Strings(macs)
+	devices := s.restoreDevices(macs)
+
+	m.Lock()
+	wasActive := m.state.Active
+	m.state.Active = false
+	if wasActive {
+		m.state.EndedAt = time.Now().UTC()
+		m.state.Devices = devices
+	}
+	state := m.state
+	m.Unlock()
+	if wasActive {
+		rlog.Infof("Demand response ended, %v devices restored", len(devices))
+		s.sendDemand(state)
+	}
+	return state
+}
+
+//applyDemand send the shed or restored values of a device, the setting hooks are skipped
+//so that they are not taken as a new setting received during the demand response
+func (s *Service) applyDemand(conf dhvac.HvacConf) error {
+	d, ok := s.hvacs.Get(strings.ToUpper(conf.Mac))
+	if !ok {
+		return NewError("Device " + conf.Mac + " not found")
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return err
+	}
+	if !hvac.IsConfigured {
+		return NewError("Device " + conf.Mac + " not configured")
+	}
+	driver := s.deviceDriver(hvac.Mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot get token info from " + conf.Mac)
+		return err
+	}
+	err = driver.ApplyConf(conf, *hvac, token)
+	if err != nil {
+		return err
+	}
+	s.trackSetpoints(conf)
+	return nil
+}
+
+//receivedDemandSetting keep the mode and setpoints of a setting received by a shed device as
+//the values to restore, the setting sends their shed values instead
+func (s *Service) receivedDemandSetting(conf *dhvac.HvacConf) {
+	m := s.demand
+	m.Lock()
+	defer m.Unlock()
+	mac := strings.ToUpper(conf.Mac)
+	device, ok := m.devices[mac]
+	if !ok {
+		return
+	}
+	if m.restoring[mac] {
+		// the setting is applied as received and becomes the values to restore
+		received := *conf
+		device.Previous = holdAction(device.Previous, shedAction(device.Previous, m.level), &received)
+		return
+	}
+	previous := holdAction(device.Previous, shedAction(device.Previous, m.level), conf)
+	if sameAction(previous, device.Previous) {
+		return
+	}
+	device.Previous = previous
+	shed := shedAction(previous, m.level)
+	received := confActionFields(conf)
+	for i, value := range actionFields(&shed) {
+		if *value != nil {
+			*received[i] = *value
+		}
+	}
+	rlog.Info("Setting of " + device.Mac + " kept until the end of the demand response")
+}
+
+//demandEconomy return true while the demand response forces the occupancy mode of a device
+func (s *Service) demandEconomy(mac string) bool {
+	m := s.demand
+	m.Lock()
+	defer m.Unlock()
+	device, ok := m.devices[strings.ToUpper(mac)]
+	return ok && device.Previous.TargetMode != nil
+}
+
+func (s *Service) sendDemand(state core.DemandState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump demand response %v", err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+net.UrlDemand, dump)
+}
+
+//receivedDemand apply a demand response received on MQTT
+func (s *Service) receivedDemand(request core.DemandRequest) {
+	_, err := s.DemandResponse(request)
+	if err != nil {
+		rlog.Error(err.Error())
+	}
+}
+
+//retryRestore restore again the devices whose restoration failed, the removed devices are
+//forgotten. m.running must be held
+func (s *Service) retryRestore() {
+	m := s.demand
+	m.Lock()
+	macs := []string{}
+	for mac := range m.restoring {
+		if _, ok := s.hvacs.Get(mac); !ok {
+			rlog.Warn("Demand response restoration of removed device " + mac + " abandoned")
+			delete(m.restoring, mac)
+			delete(m.devices, mac)
+			continue
+		}
+		macs = append(macs, mac)
+	}
+	m.Unlock()
+	if len(macs) == 0 {
+		return
+	}
+	sort.Strings(macs)
+	rlog.Infof("Demand response restoration sent again to %v devices", len(macs))
+	devices := s.restoreDevices(macs)
+
+	m.Lock()
+	index := make(map[string]int)
+	for i, device := range m.state.Devices {
+		index[device.Mac] = i
+	}
+	for _, device := range devices {
+		if i, ok := index[device.Mac]; ok {
+			m.state.Devices[i] = device
+			continue
+		}
+		m.state.Devices = append(m.state.Devices, device)
+	}
+	state := m.state
+	m.Unlock()
+	s.sendDemand(state)
+}
+
+//cronDemand end the demand response at the end of its duration and restore again the devices
+//whose restoration failed
+func (s *Service) cronDemand() {
+	timer := time.NewTicker(demandTick)
+	for {
+		select {
+		case <-timer.C:
+			m := s.demand
+			m.running.Lock()
+			m.Lock()
+			expired := m.state.Active && !m.state.Request.Until.IsZero() && time.Now().After(m.state.Request.Until)
+			m.Unlock()
+			if expired {
+				rlog.Info("Demand response duration elapsed")
+				s.restoreDemand()
+			}
+			s.retryRestore()
+			m.running.Unlock()
+		}
+	}
+}
+
+//Demand return the current or last demand response
+func (s *Service) Demand() core.DemandState {
+	m := s.demand
+	m.Lock()
+	defer m.Unlock()
+	return m.state
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/romana/rlog"
+)
+
+const (
+	EventSetup        = "setup"
+	EventConf         = "conf"
+	EventNewDevice    = "newDevice"
+	EventRefresh      = "refresh"
+	EventTiming       = "timing"
+	EventLoopConf     = "loopConf"
+	EventFallback     = "fallback"
+	EventFallbackExit = "fallbackExit"
+	EventInjection    = "injection"
+	EventOccupancy    = "occupancy"
+	EventOverride     = "occupancyOverride"
+	EventDelays       = "occupancyDelays"
+	EventWindow       = "window"
+	EventDriver       = "driver"
+	EventDemand       = "demand"
+)
+
+//done, when set, receives the result of a setting
+type deviceEvent struct {
+	name    string
+	content interface{}
+	done    chan error
+}
+
+//droppable return true for the events that may be dropped from a full queue
+//Awaited events and device state transitions are never dropped
+func (evt deviceEvent) droppable() bool {
+	if evt.done != nil {
+		return false
+	}
+	switch evt.name {
+	case EventSetup, EventNewDevice, EventFallback, EventFallbackExit, EventDriver:
+		return false
+	}
+	return true
+}
+
+//wake, when signaled, tells the worker that events are queued or that the queue is released
+type deviceQueue struct {
+	events   []deviceEvent
+	wake     chan struct{}
+	pending  map[string]int
+	dropped  int
+	released bool
+}
+
+//dispatcher route events to one worker per device
+//Events for the same mac are handled in arrival order, devices run in parallel
+//The worker is the only one to modify the device state
+type dispatcher struct {
+	sync.Mutex
+	size       int
+	overflow   string
+	queues     map[string]*deviceQueue
+	handler    func(mac string, evt deviceEvent)
+	dispatched int
+	processed  int
+	dropped    int
+}
+
+func newDispatcher(conf core.DispatchConfig, handler func(mac string, evt deviceEvent)) *dispatcher {
+	size := conf.QueueSize
+	if size <= 0 {
+		size = core.DefaultQueueSize
+	}
+	overflow := conf.Overflow
+	if overflow != core.OverflowDropNewest {
+		overflow = core.OverflowDropOldest
+	}
+	return &dispatcher{
+		size:     size,
+		overflow: overflow,
+		queues:   make(map[string]*deviceQueue),
+		handler:  handler,
+	}
+}
+
+func (d *dispatcher) queue(mac string) *deviceQueue {
+	q, ok := d.queues[mac]
+	if !ok {
+		q = &deviceQueue{
+			wake:    make(chan struct{}, 1),
+			pending: make(map[string]int),
+		}
+		d.queues[mac] = q
+		go d.worker(mac, q)
+	}
+	return q
+}
+
+func (d *dispatcher) worker(mac string, q *deviceQueue) {
+	for {
+		d.Lock()
+		if q.released {
+			d.Unlock()
+			return
+		}
+		if len(q.events) == 0 {
+			d.Unlock()
+			<-q.wake
+			continue
+		}
+		evt := q.events[0]
+		q.events = q.events[1:]
+		q.pending[evt.name]--
+		d.Unlock()
+		d.handler(mac, evt)
+		d.Lock()
+		d.processed++
+		d.Unlock()
+	}
+}
+
+//dispatch never blocks, it returns false when an event has been dropped
+//An awaited event is refused with an error on its done channel rather than dropped, a state
+//transition is queued beyond the queue size
+func (d *dispatcher) dispatch(mac string, evt deviceEvent) bool {
+	mac = strings.ToUpper(mac)
+	d.Lock()
+	defer d.Unlock()
+	q := d.queue(mac)
+	if len(q.events) < d.size {
+		d.enqueue(q, evt)
+		return true
+	}
+	if d.overflow == core.OverflowDropOldest {
+		for i, old := range q.events {
+			if !old.droppable() {
+				continue
+			}
+			q.events = append(q.events[:i], q.events[i+1:]...)
+			q.pending[old.name]--
+			q.dropped++
+			d.dropped++
+			rlog.Warnf("Queue full for %v, drop oldest %v event", mac, old.name)
+			d.enqueue(q, evt)
+			return false
+		}
+	}
+	if evt.droppable() {
+		q.dropped++
+		d.dropped++
+		rlog.Warnf("Queue full for %v, drop new %v event", mac, evt.name)
+		return false
+	}
+	if evt.done != nil {
+		q.dropped++
+		d.dropped++
+		rlog.Warnf("Queue full for %v, refuse %v event", mac, evt.name)
+		evt.done <- NewError("Event queue of " + mac + " full")
+		return false
+	}
+	rlog.Warnf("Queue full for %v, %v event queued anyway", mac, evt.name)
+	d.enqueue(q, evt)
+	return true
+}
+
+//dispatchOnce skip the event when the same kind of event is already waiting
+func (d *dispatcher) dispatchOnce(mac string, evt deviceEvent) bool {
+	d.Lock()
+	q, ok := d.queues[strings.ToUpper(mac)]
+	if ok && q.pending[evt.name] > 0 {
+		d.Unlock()
+		return true
+	}
+	d.Unlock()
+	return d.dispatch(mac, evt)
+}
+
+//enqueue append an event and wake the worker, the lock must be held
+func (d *dispatcher) enqueue(q *deviceQueue, evt deviceEvent) {
+	q.events = append(q.events, evt)
+	q.pending[evt.name]++
+	d.dispatched++
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+//release remove the queue and stop the worker of a device once its queue is empty, it is
+//called by the worker of the device
+func (d *dispatcher) release(mac string) {
+	mac = strings.ToUpper(mac)
+	d.Lock()
+	defer d.Unlock()
+	q, ok := d.queues[mac]
+	if !ok || len(q.events) > 0 {
+		return
+	}
+	delete(d.queues, mac)
+	q.released = true
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dispatcher) getStats() core.DispatchStats {
+	d.Lock()
+	defer d.Unlock()
+	stats := core.DispatchStats{
+		QueueSize:  d.size,
+		Overflow:   d.overflow,
+		Dispatched: d.dispatched,
+		Processed:  d.processed,
+		Dropped:    d.dropped,
+		Devices:    make(map[string]core.DeviceQueueStats),
+	}
+	for mac, q := range d.queues {
+		stats.Devices[mac] = core.DeviceQueueStats{
+			Pending: len(q.events),
+			Dropped: q.dropped,
+		}
+	}
+	return stats
+}
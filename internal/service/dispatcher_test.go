@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+//blockedDispatcher return a dispatcher whose worker is held on a first event until unblock is closed
+func blockedDispatcher(overflow string, handled chan string) (*dispatcher, chan struct{}) {
+	unblock := make(chan struct{})
+	d := newDispatcher(core.DispatchConfig{QueueSize: 2, Overflow: overflow}, func(mac string, evt deviceEvent) {
+		if evt.name == "block" {
+			<-unblock
+		}
+		handled <- evt.name
+	})
+	d.dispatch("mac", deviceEvent{name: "block"})
+	for {
+		d.Lock()
+		waiting := len(d.queues["MAC"].events)
+		d.Unlock()
+		if waiting == 0 {
+			return d, unblock
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func handledEvents(t *testing.T, handled chan string, count int) []string {
+	names := []string{}
+	for i := 0; i < count; i++ {
+		select {
+		case name := <-handled:
+			names = append(names, name)
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, expected %v events", names, count)
+		}
+	}
+	return names
+}
+
+func TestDispatchDropOldest(t *testing.T) {
+	handled := make(chan string, 10)
+	d, unblock := blockedDispatcher(core.OverflowDropOldest, handled)
+	d.dispatch("mac", deviceEvent{name: EventSetup})
+	d.dispatch("mac", deviceEvent{name: EventRefresh})
+	if d.dispatch("mac", deviceEvent{name: EventTiming}) {
+		t.Error("refresh not dropped")
+	}
+	d.dispatch("mac", deviceEvent{name: EventFallbackExit})
+	done := make(chan error, 1)
+	if d.dispatch("mac", deviceEvent{name: EventConf, done: done}) {
+		t.Error("awaited setting queued")
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("awaited setting refused without error")
+		}
+	default:
+		t.Error("awaited setting dropped silently")
+	}
+	close(unblock)
+	names := handledEvents(t, handled, 3)
+	expected := []string{"block", EventSetup, EventFallbackExit}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("got %v, expected %v", names, expected)
+		}
+	}
+	if stats := d.getStats(); stats.Dropped != 3 {
+		t.Errorf("got %v dropped, expected 3", stats.Dropped)
+	}
+}
+
+func TestDispatchDropNewest(t *testing.T) {
+	handled := make(chan string, 10)
+	d, unblock := blockedDispatcher(core.OverflowDropNewest, handled)
+	d.dispatch("mac", deviceEvent{name: EventRefresh})
+	d.dispatch("mac", deviceEvent{name: EventTiming})
+	if d.dispatch("mac", deviceEvent{name: EventInjection}) {
+		t.Error("new injection not dropped")
+	}
+	if !d.dispatch("mac", deviceEvent{name: EventDriver}) {
+		t.Error("driver change dropped")
+	}
+	close(unblock)
+	names := handledEvents(t, handled, 4)
+	expected := []string{"block", EventRefresh, EventTiming, EventDriver}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("got %v, expected %v", names, expected)
+		}
+	}
+}
+
+func TestDispatchRelease(t *testing.T) {
+	released := make(chan struct{})
+	var d *dispatcher
+	d = newDispatcher(core.DispatchConfig{}, func(mac string, evt deviceEvent) {
+		d.release(mac)
+		close(released)
+	})
+	d.dispatch("mac", deviceEvent{name: EventNewDevice})
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("event not handled")
+	}
+	d.Lock()
+	_, ok := d.queues["MAC"]
+	d.Unlock()
+	if ok {
+		t.Error("queue of an unknown device kept")
+	}
+}
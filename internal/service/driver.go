@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/drivers"
+	"github.com/romana/rlog"
+)
+
+//deviceDriver return the driver found when the device was plugged
+func (s *Service) deviceDriver(mac string) drivers.Driver {
+	driver, ok := s.deviceDrivers.Get(strings.ToUpper(mac))
+	if ok {
+		return driver.(drivers.Driver)
+	}
+	return s.registry.Default()
+}
+
+//setDeviceDriver change the driver of a device, e.g. once it has been reflashed
+//It updates the status, it is run by the worker of the device
+func (s *Service) setDeviceDriver(mac string, driver drivers.Driver) {
+	mac = strings.ToUpper(mac)
+	s.deviceDrivers.Set(mac, driver)
+	s.legacies.Remove(mac)
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || hvac.Protocol == driver.Protocol() {
+		return
+	}
+	rlog.Info("HVAC " + mac + " switch from " + hvac.Protocol + " to " + driver.Protocol())
+	hvac.Protocol = driver.Protocol()
+	s.hvacs.Set(mac, *hvac)
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+const fallbackTick = 10 * time.Second
+
+//settingReceived restart the watchdog of a device, it leaves the fallback mode first
+func (s *Service) settingReceived(mac string) {
+	mac = strings.ToUpper(mac)
+	s.lastSettings.Set(mac, time.Now().UTC())
+	if s.fallbackActive(mac) {
+		s.dispatcher.dispatch(mac, deviceEvent{name: EventFallbackExit})
+	}
+}
+
+func (s *Service) fallbackActive(mac string) bool {
+	state, ok := s.fallbacks.Get(mac)
+	return ok && state.(core.FallbackState).Active
+}
+
+//fallbackDue return true when no setting has been received for the timeout
+//The watchdog of a device starts with its first check
+func (s *Service) fallbackDue(mac string) bool {
+	last, ok := s.lastSettings.Get(mac)
+	if !ok {
+		s.lastSettings.Set(mac, time.Now().UTC())
+		return false
+	}
+	return elapsed(last, true, s.bridgeConf.Fallback.Timeout)
+}
+
+//currentAction return the current values of the fields changed by an action
+func currentAction(hvac dhvac.Hvac, action core.ScheduleAction) core.ScheduleAction {
+	current := core.ScheduleAction{}
+	value := func(set *int, v int) *int {
+		if set == nil {
+			return nil
+		}
+		return &v
+	}
+	current.TargetMode = value(action.TargetMode, hvac.OccManCmd1)
+	current.SetpointCoolOccupied = value(action.SetpointCoolOccupied, hvac.SetpointOccupiedCool1)
+	current.SetpointHeatOccupied = value(action.SetpointHeatOccupied, hvac.SetpointOccupiedHeat1)
+	current.SetpointCoolInoccupied = value(action.SetpointCoolInoccupied, hvac.SetpointUnoccupiedCool1)
+	current.SetpointHeatInoccupied = value(action.SetpointHeatInoccupied, hvac.SetpointUnoccupiedHeat1)
+	current.SetpointCoolStandby = value(action.SetpointCoolStandby, hvac.SetpointStandbyCool1)
+	current.SetpointHeatStandby = value(action.SetpointHeatStandby, hvac.SetpointStandbyHeat1)
+	return current
+}
+
+//holdAction move the mode and setpoints of a setting overridden by a protection into the
+//action restored at its end, they are removed from the setting
+func holdAction(previous core.ScheduleAction, protection core.ScheduleAction, conf *dhvac.HvacConf) core.ScheduleAction {
+	held := actionFields(&previous)
+	protected := actionFields(&protection)
+	received := confActionFields(conf)
+	for i := range held {
+		if *protected[i] == nil || *received[i] == nil {
+			continue
+		}
+		*held[i] = *received[i]
+		*received[i] = nil
+	}
+	return previous
+}
+
+//regulationSetup build the regulation setup changing the temperature selection
+func (s *Service) regulationSetup(hvac dhvac.Hvac, selection int) (dhvac.HvacSetup, bool) {
+	setup := dhvac.HvacSetup{
+		Mac:                  hvac.Mac,
+		TemperatureSelection: &selection,
+	}
+	if last, ok := s.setups.Get(strings.ToUpper(hvac.Mac)); ok && last.(dhvac.HvacSetup).TemperatureOffsetStep != nil {
+		setup.TemperatureOffsetStep = last.(dhvac.HvacSetup).TemperatureOffsetStep
+	} else if hvac.TemperatureOffsetStep > 0 {
+		step := hvac.TemperatureOffsetStep
+		setup.TemperatureOffsetStep = &step
+	}
+	return setup, setup.TemperatureOffsetStep != nil
+}
+
+//enterFallback switch a silent device to the configured safe mode
+func (s *Service) enterFallback(mac string) {
+	if s.fallbackActive(mac) || !s.fallbackDue(mac) {
+		return
+	}
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || !hvac.IsConfigured {
+		return
+	}
+	conf := s.bridgeConf.Fallback
+	last, _ := s.lastSettings.Get(mac)
+	state := core.FallbackState{
+		Mac:         hvac.Mac,
+		Active:      true,
+		LastSetting: last.(time.Time),
+		Since:       time.Now().UTC(),
+		Previous:    currentAction(*hvac, conf.ScheduleAction),
+	}
+
+	driver := s.deviceDriver(mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot enter fallback mode on " + mac + ": " + err.Error())
+		return
+	}
+	if conf.TemperatureSelection != nil {
+		setup, ok := s.regulationSetup(*hvac, *conf.TemperatureSelection)
+		if ok {
+			err = driver.ApplyRegulation(setup, hvac.IP, token)
+		} else {
+			err = NewError("unknown temperature offset step")
+		}
+		if err != nil {
+			rlog.Error("Cannot select the internal sensor of " + mac + ": " + err.Error())
+		}
+		if last, ok := s.setups.Get(mac); ok {
+			state.TemperatureSelection = last.(dhvac.HvacSetup).TemperatureSelection
+		}
+		if state.TemperatureSelection == nil {
+			rlog.Warn("Temperature selection of " + mac + " unknown, it will not be restored")
+		}
+	}
+	if !emptyAction(conf.ScheduleAction) {
+		err = driver.ApplyConf(scheduleConf(hvac.Mac, conf.ScheduleAction), *hvac, token)
+		if err != nil {
+			rlog.Error("Cannot apply fallback mode to " + mac + ": " + err.Error())
+		}
+	}
+	s.fallbacks.Set(mac, state)
+	rlog.Warnf("No setting received by %v since %v, fallback mode entered", mac, state.LastSetting)
+	s.sendFallback(state)
+}
+
+//exitFallback restore the values changed by the fallback mode
+func (s *Service) exitFallback(mac string) {
+	f, ok := s.fallbacks.Get(mac)
+	if !ok || !f.(core.FallbackState).Active {
+		return
+	}
+	state := f.(core.FallbackState)
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return
+	}
+	driver := s.deviceDriver(mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot leave fallback mode on " + mac + ": " + err.Error())
+		return
+	}
+	if state.TemperatureSelection != nil {
+		setup, ok := s.regulationSetup(*hvac, *state.TemperatureSelection)
+		if ok {
+			err = driver.ApplyRegulation(setup, hvac.IP, token)
+			if err != nil {
+				rlog.Error("Cannot restore the temperature selection of " + mac + ": " + err.Error())
+			}
+		}
+	}
+	if !emptyAction(state.Previous) {
+		err = driver.ApplyConf(scheduleConf(hvac.Mac, state.Previous), *hvac, token)
+		if err != nil {
+			rlog.Error("Cannot restore the mode of " + mac + ": " + err.Error())
+		}
+	}
+	state.Active = false
+	last, _ := s.lastSettings.Get(mac)
+	state.LastSetting = last.(time.Time)
+	s.fallbacks.Set(mac, state)
+	rlog.Info("Settings received by " + mac + ", fallback mode left")
+	s.sendFallback(state)
+}
+
+func (s *Service) sendFallback(state core.FallbackState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v fallback %v", state.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+state.Mac+"/"+net.UrlFallback, dump)
+}
+
+//cronFallback check the watchdog of the configured devices
+func (s *Service) cronFallback() {
+	timer := time.NewTicker(fallbackTick)
+	for {
+		select {
+		case <-timer.C:
+			if !s.bridgeConf.Fallback.Enabled {
+				continue
+			}
+			for mac, d := range s.hvacs.Items() {
+				hvac, err := dhvac.ToHvac(d)
+				if err != nil || !hvac.IsConfigured || s.fallbackActive(mac) {
+					continue
+				}
+				if s.fallbackDue(mac) {
+					s.dispatcher.dispatchOnce(mac, deviceEvent{name: EventFallback})
+				}
+			}
+		}
+	}
+}
+
+//DeviceFallback return the fallback state of a device
+func (s *Service) DeviceFallback(mac string) (*core.FallbackState, bool) {
+	mac = strings.ToUpper(mac)
+	if _, ok := s.hvacs.Get(mac); !ok {
+		return nil, false
+	}
+	state := core.FallbackState{Mac: mac}
+	if f, ok := s.fallbacks.Get(mac); ok {
+		state = f.(core.FallbackState)
+	}
+	if last, ok := s.lastSettings.Get(mac); ok {
+		state.LastSetting = last.(time.Time)
+	}
+	return &state, true
+}
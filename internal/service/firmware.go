@@ -6,13 +6,16 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/energieip/common-components-go/pkg/dhvac"
 
 	"github.com/energieip/swh200-rest2mqtt-go/internal/api"
 	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/drivers"
 	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/tftp"
 
 	pkg "github.com/energieip/common-components-go/pkg/service"
 	"github.com/energieip/common-components-go/pkg/tools"
@@ -39,18 +42,47 @@ func NewError(text string) error {
 
 //Service content
 type Service struct {
-	local        net.ServerNetwork //local broker for drivers
-	Mac          string            //Switch mac address
-	label        string
-	events       chan string
-	timerDump    time.Duration //in seconds
-	ip           string
-	isConfigured bool
-	hvacs        cmap.ConcurrentMap
-	conf         pkg.ServiceConfig
-	clientID     string
-	driversSeen  cmap.ConcurrentMap
-	api          *api.API
+	local          *net.ServerNetwork //local broker for drivers
+	Mac            string             //Switch mac address
+	label          string
+	events         chan string
+	timerDump      time.Duration //in seconds
+	ip             string
+	isConfigured   bool
+	hvacs          cmap.ConcurrentMap
+	conf           pkg.ServiceConfig
+	bridgeConf     core.BridgeConfig
+	clientID       string
+	driversSeen    cmap.ConcurrentMap
+	published      cmap.ConcurrentMap
+	lastRefresh    cmap.ConcurrentMap
+	lastHello      cmap.ConcurrentMap
+	refreshFreqs   cmap.ConcurrentMap
+	savingFreqs    sync.Mutex //serializes the saves of refreshFreqs
+	api            *api.API
+	dispatcher     *dispatcher
+	updates        *updateManager
+	tftp           *tftp.Server
+	registry       *drivers.Registry
+	deviceDrivers  cmap.ConcurrentMap
+	loops          cmap.ConcurrentMap
+	publishedLoops cmap.ConcurrentMap
+	tokens         cmap.ConcurrentMap
+	schedules      *scheduleManager
+	setups         cmap.ConcurrentMap
+	lastSettings   cmap.ConcurrentMap
+	fallbacks      cmap.ConcurrentMap
+	injections     cmap.ConcurrentMap
+	occupancies    cmap.ConcurrentMap
+	windows        cmap.ConcurrentMap
+	condensations  cmap.ConcurrentMap
+	commanded      cmap.ConcurrentMap
+	productTypes   cmap.ConcurrentMap
+	legacies       cmap.ConcurrentMap //devices waiting for their modbus to REST update
+	airQualities   cmap.ConcurrentMap
+	changeover     *changeoverManager
+	demand         *demandManager
+	alarms         *alarmManager
 }
 
 //Initialize service
@@ -58,6 +90,25 @@ func (s *Service) Initialize(confFile string) error {
 	s.events = make(chan string)
 	s.hvacs = cmap.New()
 	s.driversSeen = cmap.New()
+	s.published = cmap.New()
+	s.lastRefresh = cmap.New()
+	s.lastHello = cmap.New()
+	s.refreshFreqs = cmap.New()
+	s.legacies = cmap.New()
+	s.deviceDrivers = cmap.New()
+	s.loops = cmap.New()
+	s.publishedLoops = cmap.New()
+	s.tokens = cmap.New()
+	s.setups = cmap.New()
+	s.lastSettings = cmap.New()
+	s.fallbacks = cmap.New()
+	s.injections = cmap.New()
+	s.occupancies = cmap.New()
+	s.windows = cmap.New()
+	s.condensations = cmap.New()
+	s.commanded = cmap.New()
+	s.productTypes = cmap.New()
+	s.airQualities = cmap.New()
 
 	conf, err := pkg.ReadServiceConfig(confFile)
 	if err != nil {
@@ -66,8 +117,24 @@ func (s *Service) Initialize(confFile string) error {
 	}
 	s.conf = *conf
 
+	bridgeConf, err := core.ReadBridgeConfig(confFile)
+	if err != nil {
+		rlog.Error("Cannot parse rest2mqtt configuration " + err.Error())
+		return err
+	}
+	s.bridgeConf = *bridgeConf
+	freqs, err := core.ReadRefreshFrequencies(s.bridgeConf.Timing.File)
+	if err != nil {
+		rlog.Error("Cannot read refresh frequencies " + err.Error())
+		return err
+	}
+	for mac, freq := range freqs {
+		s.refreshFreqs.Set(strings.ToUpper(mac), freq)
+	}
+
 	mac, _ := tools.GetNetworkInfo()
 	s.Mac = mac
+	s.clientID = "rest2mqtt-" + strings.Replace(mac, ":", "", -1)
 
 	os.Setenv("RLOG_LOG_LEVEL", conf.LogLevel)
 	os.Setenv("RLOG_LOG_NOTIME", "yes")
@@ -76,22 +143,128 @@ func (s *Service) Initialize(confFile string) error {
 	rlog.Info("Starting rest2mqtt service")
 
 	s.timerDump = DefaultTimerDump
+	s.dispatcher = newDispatcher(s.bridgeConf.Dispatch, s.handleDeviceEvent)
+	s.updates = newUpdateManager()
+	schedules, err := newScheduleManager(s.bridgeConf.Schedules)
+	if err != nil {
+		rlog.Error("Cannot read schedules " + err.Error())
+		return err
+	}
+	s.schedules = schedules
+	s.changeover = newChangeoverManager(s.bridgeConf.Changeover)
+	s.demand = newDemandManager()
+	s.alarms = newAlarmManager(s.bridgeConf.Alarms.History)
+	if s.bridgeConf.Tftp.Enabled {
+		server, err := tftp.NewServer(s.bridgeConf.Tftp)
+		if err != nil {
+			rlog.Error("Cannot start TFTP server " + err.Error())
+			return err
+		}
+		server.OnTransfer = s.onTftpTransfer
+		s.tftp = server
+		go func() {
+			err := server.Run()
+			if err != nil {
+				rlog.Error("TFTP server stopped " + err.Error())
+			}
+		}()
+	}
+
+	registry, err := drivers.NewRegistry(drivers.Config{
+		Service: s.conf,
+		Bridge:  s.bridgeConf,
+	})
+	if err != nil {
+		rlog.Error("Cannot create HVAC drivers " + err.Error())
+		return err
+	}
+	s.registry = registry
 
-	broker, err := net.CreateServerNetwork()
+	broker, err := net.CreateServerNetwork(s.clientID, *conf, s.bridgeConf.Mqtt)
 	if err != nil {
 		rlog.Error("Cannot connect to broker " + conf.LocalBroker.IP + " error: " + err.Error())
 		return err
 	}
-	s.local = *broker
+	s.local = broker
 
 	go s.local.Connect(*conf)
-	web := api.InitAPI(*conf)
+	web := api.InitAPI(*conf, s)
 	s.api = web
 	go s.coldBootStart()
 	rlog.Info("rest2mqtt service started")
 	return nil
 }
 
+//BufferStats return the MQTT offline buffer metrics
+func (s *Service) BufferStats() core.BufferStats {
+	return s.local.BufferStats()
+}
+
+//DispatchStats return the device event queues metrics
+func (s *Service) DispatchStats() core.DispatchStats {
+	return s.dispatcher.getStats()
+}
+
+//handleDeviceEvent apply an event in the worker of its device, the queue of a device which is not
+//or no longer known is released once empty
+func (s *Service) handleDeviceEvent(mac string, evt deviceEvent) {
+	defer func() {
+		if _, ok := s.hvacs.Get(mac); !ok {
+			s.dispatcher.release(mac)
+		}
+	}()
+	switch evt.name {
+	case EventSetup:
+		s.receivedHvacSetup(evt.content.(dhvac.HvacSetup))
+	case EventConf:
+		err := s.receivedHvacUpdate(evt.content.(dhvac.HvacConf))
+		if evt.done != nil {
+			evt.done <- err
+		}
+	case EventDemand:
+		err := s.applyDemand(evt.content.(dhvac.HvacConf))
+		if evt.done != nil {
+			evt.done <- err
+		}
+	case EventNewDevice:
+		s.reloadHvac(evt.content)
+	case EventTiming:
+		s.receivedHvacTiming(mac, evt.content.(core.HvacTiming))
+	case EventLoopConf:
+		s.receivedHvacLoopConf(evt.content.(core.HvacLoopConf))
+	case EventFallback:
+		s.enterFallback(mac)
+	case EventFallbackExit:
+		s.exitFallback(mac)
+	case EventInjection:
+		s.refreshInjection(mac)
+	case EventOccupancy:
+		s.evaluateOccupancy(mac)
+	case EventOverride:
+		s.receivedOccupancyOverride(evt.content.(core.OccupancyOverride))
+	case EventDelays:
+		s.receivedOccupancyDelays(mac, evt.content.(core.OccupancyDelays))
+	case EventWindow:
+		s.evaluateWindow(mac)
+	case EventDriver:
+		s.setDeviceDriver(mac, evt.content.(drivers.Driver))
+	case EventRefresh:
+		d, ok := s.hvacs.Get(mac)
+		if !ok {
+			return
+		}
+		driver, err := dhvac.ToHvac(d)
+		if err != nil {
+			return
+		}
+		s.sendRefresh(*driver)
+		s.evaluateCondensation(mac)
+		s.evaluateAirQuality(mac)
+		s.evaluateAlarms(mac)
+	}
+	s.publishOnChange(mac)
+}
+
 func (s *Service) coldBootStart() {
 	nmap := exec.Command("nmap", "-sP", "10.0.0.0/24")
 	filter := exec.Command("awk", "/Nmap scan report for/{printf $5;}/MAC Address:/{print \" => \"$3;}")
@@ -127,7 +300,7 @@ func (s *Service) coldBootStart() {
 			IP:  ip,
 		}
 		rlog.Info("coldBoot found device : ", device)
-		go s.newHvac(device)
+		s.dispatcher.dispatch(device.Mac, deviceEvent{name: EventNewDevice, content: device})
 	}
 	rlog.Info("End coldBoot device Scan")
 	r.Close()
@@ -167,7 +340,7 @@ func (s *Service) nmapScan() {
 			IP:  ip,
 		}
 		rlog.Info("nmap found device : ", device)
-		go s.reloadHvac(device)
+		s.dispatcher.dispatch(device.Mac, deviceEvent{name: EventNewDevice, content: device})
 	}
 	rlog.Info("End nmap device Scan")
 	r.Close()
@@ -177,32 +350,40 @@ func (s *Service) nmapScan() {
 func (s *Service) Stop() {
 	rlog.Info("Stopping rest2mqtt service")
 	s.local.Disconnect()
+	if s.tftp != nil {
+		s.tftp.Stop()
+	}
 	rlog.Info("rest2mqtt service stopped")
 }
 
 func (s *Service) cronRefreshData() {
-	timerDump := time.NewTicker(s.timerDump * time.Millisecond)
+	timerDump := time.NewTicker(schedulerTick)
 	for {
 		select {
 		case <-timerDump.C:
-			for _, v := range s.hvacs.Items() {
-				driver, _ := dhvac.ToHvac(v)
-				go s.sendRefresh(*driver)
+			for mac := range s.hvacs.Items() {
+				if !s.refreshDue(mac) {
+					continue
+				}
+				s.lastRefresh.Set(mac, time.Now().UTC())
+				s.dispatcher.dispatchOnce(mac, deviceEvent{name: EventRefresh})
 			}
 		}
 	}
 }
 
 func (s *Service) cronDump() {
-	timerDump := time.NewTicker(s.timerDump * time.Millisecond)
+	timerDump := time.NewTicker(schedulerTick)
 	for {
 		select {
 		case <-timerDump.C:
 			for _, v := range s.hvacs.Items() {
 				driver, _ := dhvac.ToHvac(v)
 				if driver.IsConfigured {
-					s.sendDump(*driver)
-				} else {
+					if s.heartbeatDue(*driver) {
+						s.sendDump(*driver)
+					}
+				} else if s.helloDue(*driver) {
 					s.sendHello(*driver)
 				}
 			}
@@ -225,23 +406,74 @@ func (s *Service) Run() error {
 	go s.cronDump()
 	go s.cronNmap()
 	go s.cronRefreshData()
+	go s.cronFirmware()
+	go s.cronSchedules()
+	go s.cronFallback()
+	go s.cronInjection()
+	go s.cronOccupancy()
+	go s.cronWindow()
+	go s.cronChangeover()
+	go s.cronDemand()
 	for {
 		select {
 		case evtUpdate := <-s.local.EventsConf:
-			for _, event := range evtUpdate {
-				go s.receivedHvacUpdate(event)
+			for mac, event := range evtUpdate {
+				s.settingReceived(mac)
+				s.dispatcher.dispatch(mac, deviceEvent{name: EventConf, content: event})
 			}
 
 		case evtSetup := <-s.local.EventsSetup:
-			for _, event := range evtSetup {
-				go s.receivedHvacSetup(event)
+			for mac, event := range evtSetup {
+				s.settingReceived(mac)
+				s.dispatcher.dispatch(mac, deviceEvent{name: EventSetup, content: event})
 			}
 
+		case evtTiming := <-s.local.EventsTiming:
+			s.dispatcher.dispatch(evtTiming.Mac, deviceEvent{name: EventTiming, content: evtTiming})
+
+		case evtFirmware := <-s.local.EventsFirmware:
+			go s.manualUpdate(evtFirmware)
+
+		case evtGroup := <-s.local.EventsGroup:
+			go func() { s.sendGroupResult(s.GroupCommand(evtGroup)) }()
+
+		case evtOutside := <-s.local.EventsOutside:
+			go s.receivedOutside(evtOutside)
+
+		case evtDemand := <-s.local.EventsDemand:
+			go s.receivedDemand(evtDemand)
+
+		case evtAck := <-s.local.EventsAlarmAck:
+			s.receivedAlarmAck(evtAck)
+
+		case evtLoop := <-s.local.EventsLoop:
+			s.dispatcher.dispatch(evtLoop.Mac, deviceEvent{name: EventLoopConf, content: evtLoop})
+
 		case evtAPI := <-s.api.EventsToBackend:
 			for evtType, content := range evtAPI {
 				switch evtType {
 				case "newDevice":
-					go s.reloadHvac(content)
+					driver, err := core.ToDevice(content)
+					if err != nil || driver == nil {
+						continue
+					}
+					s.dispatcher.dispatch(driver.Mac, deviceEvent{name: EventNewDevice, content: content})
+				case "firmwareUpdate":
+					go s.manualUpdate(content.(core.FirmwareRequest))
+				case "timing":
+					timing := content.(core.HvacTiming)
+					s.dispatcher.dispatch(timing.Mac, deviceEvent{name: EventTiming, content: timing})
+				case "occupancyOverride":
+					override := content.(core.OccupancyOverride)
+					s.dispatcher.dispatch(override.Mac, deviceEvent{name: EventOverride, content: override})
+				case "occupancyDelays":
+					delays := content.(core.OccupancyDelaysRequest)
+					s.dispatcher.dispatch(delays.Mac, deviceEvent{name: EventDelays, content: delays.OccupancyDelays})
+				case "outsideTemperature":
+					go s.receivedOutside(content.(core.OutsideTemperature))
+				case "loopConf":
+					conf := content.(core.HvacLoopConf)
+					s.dispatcher.dispatch(conf.Mac, deviceEvent{name: EventLoopConf, content: conf})
 				}
 			}
 		}
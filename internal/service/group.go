@@ -0,0 +1,132 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/pconst"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//groupMembers return the configured devices of a group sorted by mac address
+func (s *Service) groupMembers(group int) []string {
+	macs := []string{}
+	for mac, d := range s.hvacs.Items() {
+		hvac, err := dhvac.ToHvac(d)
+		if err != nil || !hvac.IsConfigured || hvac.Group != group {
+			continue
+		}
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+//groupsMembers return the configured devices of the groups, every configured device when empty
+func (s *Service) groupsMembers(groups []int) []string {
+	macs := []string{}
+	if len(groups) == 0 {
+		for mac, d := range s.hvacs.Items() {
+			hvac, err := dhvac.ToHvac(d)
+			if err != nil || !hvac.IsConfigured {
+				continue
+			}
+			macs = append(macs, mac)
+		}
+		sort.Strings(macs)
+		return macs
+	}
+	for _, group := range groups {
+		macs = append(macs, s.groupMembers(group)...)
+	}
+	return macs
+}
+
+//groupDeviceCommand queue the setting event of a device and wait for its result
+func (s *Service) groupDeviceCommand(mac string, event string, conf dhvac.HvacConf) core.GroupDeviceResult {
+	result := core.GroupDeviceResult{
+		Mac:    mac,
+		Status: core.GroupDeviceSuccess,
+	}
+	conf.Mac = mac
+	done := make(chan error, 1)
+	s.dispatcher.dispatch(mac, deviceEvent{name: event, content: conf, done: done})
+	select {
+	case err := <-done:
+		if err != nil {
+			result.Status = core.GroupDeviceFailed
+			result.Message = err.Error()
+		}
+	case <-time.After(time.Duration(s.bridgeConf.Groups.Timeout) * time.Millisecond):
+		result.Status = core.GroupDeviceTimeout
+	}
+	return result
+}
+
+//commandDevices apply its setting to each device with an EventConf or EventDemand event, at most
+//Concurrency devices at the same time
+func (s *Service) commandDevices(macs []string, event string, setting func(mac string) dhvac.HvacConf) []core.GroupDeviceResult {
+	slots := s.bridgeConf.Groups.Concurrency
+	if slots < 1 {
+		slots = 1
+	}
+	devices := make([]core.GroupDeviceResult, len(macs))
+	sem := make(chan struct{}, slots)
+	var wg sync.WaitGroup
+	for i, mac := range macs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, mac string) {
+			defer wg.Done()
+			devices[i] = s.groupDeviceCommand(mac, event, setting(mac))
+			<-sem
+		}(i, mac)
+	}
+	wg.Wait()
+	return devices
+}
+
+//GroupCommand apply a setting to every configured device of a group
+//At most Concurrency devices are commanded at the same time, each one through its event queue
+func (s *Service) GroupCommand(cmd core.GroupCommand) core.GroupResult {
+	macs := s.groupMembers(cmd.Group)
+	rlog.Infof("Group %v command for %v devices", cmd.Group, len(macs))
+	for _, mac := range macs {
+		s.settingReceived(mac)
+	}
+	devices := s.commandDevices(macs, EventConf, func(string) dhvac.HvacConf {
+		return cmd.Conf
+	})
+
+	result := core.GroupResult{
+		Group:   cmd.Group,
+		Total:   len(macs),
+		Devices: devices,
+	}
+	for _, device := range devices {
+		if device.Status == core.GroupDeviceSuccess {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+	rlog.Infof("Group %v command done: %v succeeded, %v failed", cmd.Group, result.Succeeded, result.Failed)
+	return result
+}
+
+//sendGroupResult publish the result of a group command received on MQTT
+func (s *Service) sendGroupResult(result core.GroupResult) {
+	dump, err := tools.ToJSON(result)
+	if err != nil {
+		rlog.Errorf("Could not dump group %v result %v", result.Group, err.Error())
+		return
+	}
+	topic := "/read/hvac/" + net.UrlGroup + "/" + strconv.Itoa(result.Group) + "/" + pconst.UrlSetting
+	s.local.SendCommand(net.MsgAck, topic, dump)
+}
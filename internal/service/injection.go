@@ -0,0 +1,204 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+const injectionTick = 10 * time.Second
+
+//injectedMeasures return the measures carried by a setting
+func injectedMeasures(conf dhvac.HvacConf) map[string]int {
+	measures := make(map[string]int)
+	if conf.Temperature != nil {
+		measures[core.MeasureTemperature] = *conf.Temperature
+	}
+	if conf.CO2 != nil {
+		measures[core.MeasureCO2] = *conf.CO2
+	}
+	if conf.Hygrometry != nil {
+		measures[core.MeasureHygrometry] = *conf.Hygrometry
+	}
+	return measures
+}
+
+//injectionConf build the setting writing measures again
+func injectionConf(mac string, measures map[string]int) dhvac.HvacConf {
+	conf := dhvac.HvacConf{
+		Mac: mac,
+	}
+	for name, value := range measures {
+		v := value
+		switch name {
+		case core.MeasureTemperature:
+			conf.Temperature = &v
+		case core.MeasureCO2:
+			conf.CO2 = &v
+		case core.MeasureHygrometry:
+			conf.Hygrometry = &v
+		}
+	}
+	return conf
+}
+
+//injectionState return a copy of the injected measures of a device
+func (s *Service) injectionState(mac string) core.InjectionState {
+	state := core.InjectionState{
+		Mac:      mac,
+		Measures: make(map[string]core.InjectedMeasure),
+	}
+	if i, ok := s.injections.Get(mac); ok {
+		for name, measure := range i.(core.InjectionState).Measures {
+			state.Measures[name] = measure
+		}
+	}
+	return state
+}
+
+//trackInjection record the measures of a setting applied to a device
+func (s *Service) trackInjection(conf dhvac.HvacConf) {
+	measures := injectedMeasures(conf)
+	if len(measures) == 0 {
+		return
+	}
+	mac := strings.ToUpper(conf.Mac)
+	state := s.injectionState(mac)
+	now := time.Now().UTC()
+	changed := false
+	for name, value := range measures {
+		if state.Measures[name].Stale {
+			rlog.Info(name + " of " + mac + " injected again")
+			changed = true
+		}
+		state.Measures[name] = core.InjectedMeasure{
+			Value:      value,
+			ReceivedAt: now,
+			SentAt:     now,
+		}
+	}
+	s.injections.Set(mac, state)
+	if changed {
+		s.sendInjection(state)
+	}
+}
+
+func (s *Service) measureStale(measure core.InjectedMeasure) bool {
+	maxAge := s.bridgeConf.Injection.MaxAge
+	return maxAge > 0 && elapsed(measure.ReceivedAt, true, maxAge)
+}
+
+func (s *Service) measureResendDue(measure core.InjectedMeasure) bool {
+	heartbeat := s.bridgeConf.Injection.Heartbeat
+	return heartbeat > 0 && elapsed(measure.SentAt, true, heartbeat)
+}
+
+//injectionDue return true when a measure has to be written again or flagged stale
+func (s *Service) injectionDue(mac string) bool {
+	i, ok := s.injections.Get(mac)
+	if !ok {
+		return false
+	}
+	for _, measure := range i.(core.InjectionState).Measures {
+		if !measure.Stale && (s.measureStale(measure) || s.measureResendDue(measure)) {
+			return true
+		}
+	}
+	return false
+}
+
+//refreshInjection flag the measures older than the max age and write the others again
+func (s *Service) refreshInjection(mac string) {
+	state := s.injectionState(mac)
+	changed := false
+	resend := make(map[string]int)
+	for name, measure := range state.Measures {
+		if measure.Stale {
+			continue
+		}
+		if s.measureStale(measure) {
+			measure.Stale = true
+			state.Measures[name] = measure
+			changed = true
+			rlog.Warnf("%v of %v not injected since %v, no longer written", name, mac, measure.ReceivedAt)
+			continue
+		}
+		if s.measureResendDue(measure) {
+			resend[name] = measure.Value
+		}
+	}
+	if len(resend) > 0 && s.resendInjection(mac, resend) {
+		now := time.Now().UTC()
+		for name := range resend {
+			measure := state.Measures[name]
+			measure.SentAt = now
+			state.Measures[name] = measure
+		}
+	}
+	s.injections.Set(mac, state)
+	if changed {
+		s.sendInjection(state)
+	}
+}
+
+func (s *Service) resendInjection(mac string, measures map[string]int) bool {
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return false
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || !hvac.IsConfigured {
+		return false
+	}
+	driver := s.deviceDriver(mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot get token info from " + mac)
+		return false
+	}
+	err = driver.ApplyConf(injectionConf(hvac.Mac, measures), *hvac, token)
+	if err != nil {
+		rlog.Error("Cannot write the injected measures of " + mac + ": " + err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Service) sendInjection(state core.InjectionState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v injection %v", state.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+state.Mac+"/"+net.UrlInjection, dump)
+}
+
+//cronInjection check the age of the injected measures
+func (s *Service) cronInjection() {
+	timer := time.NewTicker(injectionTick)
+	for {
+		select {
+		case <-timer.C:
+			for mac := range s.injections.Items() {
+				if s.injectionDue(mac) {
+					s.dispatcher.dispatchOnce(mac, deviceEvent{name: EventInjection})
+				}
+			}
+		}
+	}
+}
+
+//DeviceInjection return the measures injected in a device
+func (s *Service) DeviceInjection(mac string) (*core.InjectionState, bool) {
+	mac = strings.ToUpper(mac)
+	if _, ok := s.hvacs.Get(mac); !ok {
+		return nil, false
+	}
+	state := s.injectionState(mac)
+	return &state, true
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+const (
+	windowTick     = time.Second
+	windowRetry    = 10 * time.Second
+	windowRetryMax = 5 * time.Minute
+)
+
+func (s *Service) windowState(mac string) core.WindowState {
+	if w, ok := s.windows.Get(mac); ok {
+		return w.(core.WindowState)
+	}
+	return core.WindowState{
+		Mac: mac,
+	}
+}
+
+//receivedWindow record the window contact of a setting, it is removed from the setting
+//and sent once debounced. While the protection is applied, the mode and setpoints it
+//overrides are kept for the restore instead of being applied
+func (s *Service) receivedWindow(hvac dhvac.Hvac, conf *dhvac.HvacConf) {
+	if !s.bridgeConf.Window.Enabled {
+		return
+	}
+	state := s.windowState(strings.ToUpper(hvac.Mac))
+	if !state.Protected && conf.WindowStatus == nil {
+		return
+	}
+	if state.Protected {
+		state.Previous = holdAction(state.Previous, s.bridgeConf.Window.ScheduleAction, conf)
+	}
+	if conf.WindowStatus != nil {
+		if *conf.WindowStatus != state.Raw || state.RawSince.IsZero() {
+			state.Raw = *conf.WindowStatus
+			state.RawSince = time.Now().UTC()
+		}
+		conf.WindowStatus = nil
+	}
+	s.windows.Set(state.Mac, state)
+}
+
+//windowDue return true when the debounced contact or the protection has to change, not before
+//the retry date of a device which could not be written
+func windowDue(state core.WindowState, conf core.WindowConfig, now time.Time) bool {
+	if now.Before(state.RetryAt) {
+		return false
+	}
+	if state.Raw != state.Open && now.Sub(state.RawSince) >= time.Duration(conf.Debounce)*time.Millisecond {
+		return true
+	}
+	if state.Open && !state.Protected && now.Sub(state.OpenSince) >= time.Duration(conf.ProtectionDelay)*time.Millisecond {
+		return true
+	}
+	return !state.Open && state.Protected
+}
+
+//evaluateWindow send the hold-off of the debounced contact, apply the protection when the
+//window stays open and restore the previous mode and setpoints when it closes
+func (s *Service) evaluateWindow(mac string) {
+	conf := s.bridgeConf.Window
+	state := s.windowState(mac)
+	now := time.Now().UTC()
+	if !windowDue(state, conf, now) {
+		return
+	}
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || !hvac.IsConfigured {
+		return
+	}
+
+	setting := dhvac.HvacConf{
+		Mac: hvac.Mac,
+	}
+	if state.Raw != state.Open && now.Sub(state.RawSince) >= time.Duration(conf.Debounce)*time.Millisecond {
+		state.Open = state.Raw
+		if state.Open {
+			state.OpenSince = now
+		}
+		open := state.Open
+		setting.WindowStatus = &open
+	}
+	protect := state.Open && !state.Protected && now.Sub(state.OpenSince) >= time.Duration(conf.ProtectionDelay)*time.Millisecond
+	restore := !state.Open && state.Protected
+	if protect {
+		state.Previous = currentAction(*hvac, conf.ScheduleAction)
+		setting = scheduleConf(hvac.Mac, conf.ScheduleAction)
+		setting.WindowStatus = &state.Open
+	} else if restore {
+		setting = scheduleConf(hvac.Mac, state.Previous)
+		setting.WindowStatus = &state.Open
+	}
+
+	driver := s.deviceDriver(mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot get token info from " + mac)
+		s.windowFailed(mac, now)
+		return
+	}
+	err = driver.ApplyConf(setting, *hvac, token)
+	if err != nil {
+		rlog.Error("Cannot apply window interlock to " + mac + ": " + err.Error())
+		s.windowFailed(mac, now)
+		return
+	}
+	switch {
+	case protect:
+		state.Protected = true
+		rlog.Infof("Window of %v open since %v, protection applied", mac, state.OpenSince)
+	case restore:
+		state.Protected = false
+		state.Previous = core.ScheduleAction{}
+		rlog.Info("Window of " + mac + " closed, previous mode restored")
+	default:
+		rlog.Infof("Window of %v open: %v", mac, state.Open)
+	}
+	state.ChangedAt = now
+	state.Failures = 0
+	state.RetryAt = time.Time{}
+	s.windows.Set(mac, state)
+	s.sendWindow(state)
+}
+
+//windowFailed delay the next evaluation of a device which could not be written
+func (s *Service) windowFailed(mac string, now time.Time) {
+	state := s.windowState(mac)
+	delay := windowRetryMax
+	if state.Failures < 5 {
+		delay = windowRetry << uint(state.Failures)
+	}
+	state.Failures++
+	state.RetryAt = now.Add(delay)
+	s.windows.Set(mac, state)
+	rlog.Infof("Window interlock of %v tried again at %v", mac, state.RetryAt)
+}
+
+//windowProtected return true while the protection of an open window is applied
+func (s *Service) windowProtected(mac string) bool {
+	w, ok := s.windows.Get(mac)
+	return ok && w.(core.WindowState).Protected
+}
+
+func (s *Service) sendWindow(state core.WindowState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v window %v", state.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+state.Mac+"/"+net.UrlWindow, dump)
+}
+
+//cronWindow apply the debounce and protection delays
+func (s *Service) cronWindow() {
+	timer := time.NewTicker(windowTick)
+	for {
+		select {
+		case <-timer.C:
+			now := time.Now()
+			for mac, w := range s.windows.Items() {
+				if windowDue(w.(core.WindowState), s.bridgeConf.Window, now) {
+					s.dispatcher.dispatchOnce(mac, deviceEvent{name: EventWindow})
+				}
+			}
+		}
+	}
+}
+
+//OpenWindows return the devices whose window is open, sorted by mac address
+func (s *Service) OpenWindows() []core.WindowState {
+	windows := []core.WindowState{}
+	for _, w := range s.windows.Items() {
+		if w.(core.WindowState).Open {
+			windows = append(windows, w.(core.WindowState))
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Mac < windows[j].Mac
+	})
+	return windows
+}
+
+//DeviceWindow return the window interlock state of a device
+func (s *Service) DeviceWindow(mac string) (*core.WindowState, bool) {
+	mac = strings.ToUpper(mac)
+	if _, ok := s.hvacs.Get(mac); !ok {
+		return nil, false
+	}
+	state := s.windowState(mac)
+	return &state, true
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/drivers"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//refreshLoops read the regulation loops used after the first one
+func (s *Service) refreshLoops(driver drivers.Driver, status dhvac.Hvac, token string) {
+	loops, err := driver.ReadLoops(status, token)
+	if err != nil {
+		rlog.Error("Cannot get regulation loops from " + status.Mac)
+		return
+	}
+	s.loops.Set(strings.ToUpper(status.Mac), loops)
+}
+
+func loopTopic(mac string, loop int) string {
+	return "/read/hvac/" + mac + "/" + net.UrlLoop + strconv.Itoa(loop)
+}
+
+//loopChanged compare two loop status, temperatures moving less than the deadband are ignored
+func loopChanged(previous, current core.HvacLoopStatus, conf core.PublishConfig) bool {
+	if withinDeadband(previous.SpaceTemp, current.SpaceTemp, conf.TemperatureDeadband) {
+		current.SpaceTemp = previous.SpaceTemp
+	}
+	return previous != current
+}
+
+//sendLoops publish the loops of a device on /read/hvac/{mac}/loop{n}, only the changed ones unless forced
+func (s *Service) sendLoops(mac string, force bool) {
+	l, ok := s.loops.Get(strings.ToUpper(mac))
+	if !ok {
+		return
+	}
+	for _, loop := range l.([]core.HvacLoopStatus) {
+		topic := loopTopic(loop.Mac, loop.Loop)
+		last, ok := s.publishedLoops.Get(topic)
+		if !force && ok && !loopChanged(last.(core.HvacLoopStatus), loop, s.bridgeConf.Publish) {
+			continue
+		}
+		dump, err := tools.ToJSON(loop)
+		if err != nil {
+			rlog.Errorf("Could not dump HVAC %v loop %v status %v", loop.Mac, loop.Loop, err.Error())
+			continue
+		}
+		s.local.SendCommand(net.MsgStatus, topic, dump)
+		s.publishedLoops.Set(topic, loop)
+	}
+}
+
+func (s *Service) receivedHvacLoopConf(conf core.HvacLoopConf) {
+	d, ok := s.hvacs.Get(strings.ToUpper(conf.Mac))
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil || !hvac.IsConfigured {
+		return
+	}
+	driver := s.deviceDriver(hvac.Mac)
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		rlog.Error("Cannot get token info from " + conf.Mac)
+		return
+	}
+	err = driver.ApplyLoopConf(conf, *hvac, token)
+	if err != nil {
+		rlog.Error("Cannot apply loop" + strconv.Itoa(conf.Loop) + " config to " + conf.Mac + ": " + err.Error())
+	}
+}
+
+//DeviceLoops return the regulation loops used after the first one
+func (s *Service) DeviceLoops(mac string) ([]core.HvacLoopStatus, bool) {
+	if _, ok := s.hvacs.Get(strings.ToUpper(mac)); !ok {
+		return nil, false
+	}
+	l, ok := s.loops.Get(strings.ToUpper(mac))
+	if !ok {
+		return []core.HvacLoopStatus{}, true
+	}
+	return l.([]core.HvacLoopStatus), true
+}
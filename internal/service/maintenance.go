@@ -0,0 +1,115 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+//maintenanceField set of allowed values of a cron field
+type maintenanceField map[int]bool
+
+//parseMaintenanceField parse "*", "a", "a-b", "*/n", "a-b/n" and comma separated lists
+func parseMaintenanceField(field string, min, max int) (maintenanceField, error) {
+	values := make(maintenanceField)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, NewError("Invalid cron step " + part)
+			}
+			step = n
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, NewError("Invalid cron value " + part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, NewError("Invalid cron value " + part)
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, NewError("Cron value out of range " + part)
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+//maintenanceCron maintenance window "minute hour day-of-month month day-of-week" expression
+//As in cron, when both the day of month and the day of week are restricted either one matches
+type maintenanceCron struct {
+	minutes    maintenanceField
+	hours      maintenanceField
+	days       maintenanceField
+	months     maintenanceField
+	weekdays   maintenanceField
+	anyDay     bool
+	anyWeekday bool
+}
+
+func parseMaintenanceCron(expr string) (*maintenanceCron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, NewError("Invalid cron expression " + expr)
+	}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]maintenanceField, 5)
+	for i, field := range fields {
+		values, err := parseMaintenanceField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = values
+	}
+	if parsed[4][7] {
+		// sunday can be written 0 or 7
+		parsed[4][0] = true
+	}
+	return &maintenanceCron{
+		minutes:    parsed[0],
+		hours:      parsed[1],
+		days:       parsed[2],
+		months:     parsed[3],
+		weekdays:   parsed[4],
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (c *maintenanceCron) match(t time.Time) bool {
+	day := c.days[t.Day()] && c.weekdays[int(t.Weekday())]
+	if !c.anyDay && !c.anyWeekday {
+		day = c.days[t.Day()] || c.weekdays[int(t.Weekday())]
+	}
+	return c.minutes[t.Minute()] && c.hours[t.Hour()] && c.months[int(t.Month())] && day
+}
+
+//maintenanceOpen return true when a maintenance window started less than its duration ago
+func maintenanceOpen(window core.MaintenanceWindow, now time.Time) (bool, error) {
+	schedule, err := parseMaintenanceCron(window.Cron)
+	if err != nil {
+		return false, err
+	}
+	now = now.Truncate(time.Minute)
+	for elapsed := 0; elapsed < window.Duration; elapsed++ {
+		if schedule.match(now.Add(-time.Duration(elapsed) * time.Minute)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+func TestParseMaintenanceField(t *testing.T) {
+	tests := []struct {
+		field  string
+		min    int
+		max    int
+		values []int
+		err    bool
+	}{
+		{field: "*", min: 0, max: 3, values: []int{0, 1, 2, 3}},
+		{field: "5", min: 0, max: 59, values: []int{5}},
+		{field: "1-3", min: 0, max: 59, values: []int{1, 2, 3}},
+		{field: "*/15", min: 0, max: 59, values: []int{0, 15, 30, 45}},
+		{field: "10-20/5", min: 0, max: 59, values: []int{10, 15, 20}},
+		{field: "1,3,5-6", min: 0, max: 7, values: []int{1, 3, 5, 6}},
+		{field: "60", min: 0, max: 59, err: true},
+		{field: "3-1", min: 0, max: 59, err: true},
+		{field: "*/0", min: 0, max: 59, err: true},
+		{field: "a", min: 0, max: 59, err: true},
+		{field: "1-b", min: 0, max: 59, err: true},
+	}
+	for _, test := range tests {
+		values, err := parseMaintenanceField(test.field, test.min, test.max)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.field)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.field, err)
+			continue
+		}
+		if len(values) != len(test.values) {
+			t.Errorf("%v: got %v, expected %v", test.field, values, test.values)
+		}
+		for _, v := range test.values {
+			if !values[v] {
+				t.Errorf("%v: %v missing", test.field, v)
+			}
+		}
+	}
+}
+
+func TestParseMaintenanceCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseMaintenanceCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestMaintenanceCronMatch(t *testing.T) {
+	// 2024-01-15 is a monday
+	monday := time.Date(2024, 1, 15, 2, 30, 0, 0, time.UTC)
+	first := time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC) // thursday
+	sunday := time.Date(2024, 1, 14, 2, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr  string
+		date  time.Time
+		match bool
+	}{
+		{"30 2 * * *", monday, true},
+		{"31 2 * * *", monday, false},
+		{"30 2 * * 1", monday, true},
+		{"30 2 * * 1-5", sunday, false},
+		{"30 2 * * 0", sunday, true},
+		{"30 2 * * 7", sunday, true},
+		{"30 2 15 * *", monday, true},
+		{"30 2 1 * *", monday, false},
+		{"30 2 * 2 *", monday, false},
+		// both restricted: either the day of month or the day of week
+		{"30 2 1 * 1", monday, true},
+		{"30 2 1 * 1", first, true},
+		{"30 2 1 * 1", sunday, false},
+		// as in cron, a field starting with * is not restricted: both have to match
+		{"30 2 1 * */2", sunday, false},
+		{"30 2 */2 * 1", first, false},
+		{"30 2 */2 * 1", time.Date(2024, 1, 1, 2, 30, 0, 0, time.UTC), true},
+	}
+	for _, test := range tests {
+		schedule, err := parseMaintenanceCron(test.expr)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		if schedule.match(test.date) != test.match {
+			t.Errorf("%v on %v: expected %v", test.expr, test.date, test.match)
+		}
+	}
+}
+
+func TestMaintenanceOpen(t *testing.T) {
+	window := core.MaintenanceWindow{
+		Cron:     "0 2 * * *",
+		Duration: 60,
+	}
+	tests := []struct {
+		date time.Time
+		open bool
+	}{
+		{time.Date(2024, 1, 15, 1, 59, 0, 0, time.UTC), false},
+		{time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 1, 15, 2, 59, 30, 0, time.UTC), true},
+		{time.Date(2024, 1, 15, 3, 0, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		open, err := maintenanceOpen(window, test.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open != test.open {
+			t.Errorf("%v: expected %v", test.date, test.open)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+const occupancyTick = 10 * time.Second
+
+//presenceMode return true for the modes driven by the presence
+func presenceMode(mode int) bool {
+	return mode == dhvac.OCCUPANCY_COMFORT || mode == dhvac.OCCUPANCY_STANDBY || mode == dhvac.OCCUPANCY_ECONOMY
+}
+
+//occupancyState return the state machine of a device, it starts in the current mode of the device
+//and is driven by the presence once one has been received
+func (s *Service) occupancyState(hvac dhvac.Hvac) core.OccupancyState {
+	mac := strings.ToUpper(hvac.Mac)
+	if o, ok := s.occupancies.Get(mac); ok {
+		return o.(core.OccupancyState)
+	}
+	return core.OccupancyState{
+		Mac:     mac,
+		State:   core.OccupancyUnmanaged,
+		Mode:    hvac.OccManCmd1,
+		Managed: s.bridgeConf.Occupancy.Enabled && presenceMode(hvac.OccManCmd1),
+		Delays:  s.bridgeConf.Occupancy.OccupancyDelays,
+	}
+}
+
+//occupancyMode compute the state and mode of a device
+//The mode is kept while the presence or the absence is shorter than its delay
+func occupancyMode(state core.OccupancyState, now time.Time) (string, int) {
+	if state.Override != nil && (state.Override.Until.IsZero() || now.Before(state.Override.Until)) {
+		return core.OccupancyForced, state.Override.Mode
+	}
+	if !state.Managed || state.PresenceSince.IsZero() {
+		return core.OccupancyUnmanaged, state.Mode
+	}
+	since := now.Sub(state.PresenceSince)
+	delays := state.Delays
+	if state.Presence {
+		if since >= time.Duration(delays.OnDelay)*time.Millisecond {
+			return core.OccupancyOccupied, dhvac.OCCUPANCY_COMFORT
+		}
+		return core.OccupancyArriving, state.Mode
+	}
+	if delays.EconomyDelay > 0 && since >= time.Duration(delays.EconomyDelay)*time.Millisecond {
+		return core.OccupancyEconomy, dhvac.OCCUPANCY_ECONOMY
+	}
+	if since >= time.Duration(delays.OffDelay)*time.Millisecond {
+		return core.OccupancyStandby, dhvac.OCCUPANCY_STANDBY
+	}
+	return core.OccupancyLeaving, state.Mode
+}
+
+//receivedOccupancy feed the state machine with a setting, the presence is removed from it
+//A target mode other than comfort or standby gives the mode back to the server
+func (s *Service) receivedOccupancy(hvac dhvac.Hvac, conf *dhvac.HvacConf) {
+	if !s.bridgeConf.Occupancy.Enabled || (conf.Presence == nil && conf.TargetMode == nil) {
+		return
+	}
+	state := s.occupancyState(hvac)
+	if conf.TargetMode != nil {
+		state.Managed = *conf.TargetMode == dhvac.OCCUPANCY_COMFORT || *conf.TargetMode == dhvac.OCCUPANCY_STANDBY
+		state.Mode = *conf.TargetMode
+	}
+	if conf.Presence != nil {
+		if *conf.Presence != state.Presence || state.PresenceSince.IsZero() {
+			state.Presence = *conf.Presence
+			state.PresenceSince = time.Now().UTC()
+		}
+		conf.Presence = nil
+	}
+	s.occupancies.Set(state.Mac, state)
+}
+
+//evaluateOccupancy send the mode computed by the state machine when it changed
+func (s *Service) evaluateOccupancy(mac string) {
+	o, ok := s.occupancies.Get(mac)
+	if !ok {
+		return
+	}
+	state := o.(core.OccupancyState)
+	if state.Override != nil && !state.Override.Until.IsZero() && time.Now().After(state.Override.Until) {
+		rlog.Info("Occupancy override of " + mac + " expired")
+		state.Override = nil
+	}
+	name, mode := occupancyMode(state, time.Now())
+	changed := name != state.State
+	if mode != state.Mode && (s.windowProtected(mac) || s.demandEconomy(mac)) {
+		// applied once the window is closed or the demand response is over
+		return
+	}
+	if mode != state.Mode {
+		d, ok := s.hvacs.Get(mac)
+		if !ok {
+			return
+		}
+		hvac, err := dhvac.ToHvac(d)
+		if err != nil || !hvac.IsConfigured {
+			return
+		}
+		driver := s.deviceDriver(mac)
+		token, err := driver.Login(hvac.IP)
+		if err != nil {
+			rlog.Error("Cannot get token info from " + mac)
+			return
+		}
+		err = driver.ApplyConf(dhvac.HvacConf{Mac: hvac.Mac, TargetMode: &mode}, *hvac, token)
+		if err != nil {
+			rlog.Error("Cannot apply occupancy mode to " + mac + ": " + err.Error())
+			return
+		}
+		state.Mode = mode
+		changed = true
+	}
+	if !changed {
+		s.occupancies.Set(mac, state)
+		return
+	}
+	rlog.Infof("Occupancy %v: %v -> %v (mode %v, presence %v since %v)", mac, state.State, name, mode, state.Presence, state.PresenceSince)
+	state.State = name
+	state.ChangedAt = time.Now().UTC()
+	s.occupancies.Set(mac, state)
+	s.sendOccupancy(state)
+}
+
+//receivedOccupancyOverride force the mode of a device, a negative mode clears the override
+func (s *Service) receivedOccupancyOverride(override core.OccupancyOverride) {
+	d, ok := s.hvacs.Get(strings.ToUpper(override.Mac))
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return
+	}
+	state := s.occupancyState(*hvac)
+	if override.Mode < 0 {
+		rlog.Info("Occupancy override of " + state.Mac + " cleared")
+		state.Override = nil
+	} else {
+		if override.Duration > 0 {
+			override.Until = time.Now().UTC().Add(time.Duration(override.Duration) * time.Millisecond)
+		}
+		override.Mac = state.Mac
+		rlog.Infof("Occupancy override of %v: mode %v for %v ms", state.Mac, override.Mode, override.Duration)
+		state.Override = &override
+	}
+	s.occupancies.Set(state.Mac, state)
+	s.evaluateOccupancy(state.Mac)
+}
+
+func (s *Service) receivedOccupancyDelays(mac string, delays core.OccupancyDelays) {
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return
+	}
+	state := s.occupancyState(*hvac)
+	state.Delays = delays
+	s.occupancies.Set(state.Mac, state)
+	rlog.Infof("Occupancy delays of %v: %+v", state.Mac, delays)
+	s.evaluateOccupancy(state.Mac)
+}
+
+func (s *Service) sendOccupancy(state core.OccupancyState) {
+	dump, err := tools.ToJSON(state)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v occupancy %v", state.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+state.Mac+"/"+net.UrlOccupancy, dump)
+}
+
+//cronOccupancy apply the delays of the state machines
+func (s *Service) cronOccupancy() {
+	timer := time.NewTicker(occupancyTick)
+	for {
+		select {
+		case <-timer.C:
+			now := time.Now()
+			for mac, o := range s.occupancies.Items() {
+				state := o.(core.OccupancyState)
+				name, mode := occupancyMode(state, now)
+				if name != state.State || mode != state.Mode {
+					s.dispatcher.dispatchOnce(mac, deviceEvent{name: EventOccupancy})
+				}
+			}
+		}
+	}
+}
+
+//DeviceOccupancy return the occupancy state machine of a device
+func (s *Service) DeviceOccupancy(mac string) (*core.OccupancyState, bool) {
+	d, ok := s.hvacs.Get(strings.ToUpper(mac))
+	if !ok {
+		return nil, false
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return nil, false
+	}
+	state := s.occupancyState(*hvac)
+	return &state, true
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/romana/rlog"
+)
+
+//updateAllowed check the automatic update policy, the reason is given when it is refused
+func (s *Service) updateAllowed(now time.Time) (bool, string) {
+	conf := s.bridgeConf.Firmware
+	if !conf.AutoUpdate {
+		return false, "Automatic update disabled"
+	}
+	if len(conf.Windows) == 0 {
+		return true, ""
+	}
+	for _, window := range conf.Windows {
+		open, err := maintenanceOpen(window, now)
+		if err != nil {
+			rlog.Error("Invalid maintenance window: " + err.Error())
+			continue
+		}
+		if open {
+			return true, ""
+		}
+	}
+	return false, "Outside maintenance windows"
+}
+
+//requestAutoUpdate schedule the update now or defer it until the policy allows it
+func (s *Service) requestAutoUpdate(update core.FirmwareUpdate) {
+	allowed, reason := s.updateAllowed(time.Now())
+	if allowed {
+		s.scheduleUpdate(update)
+		return
+	}
+	mac := strings.ToUpper(update.Mac)
+	m := s.updates
+	m.Lock()
+	current, ok := m.updates[mac]
+	if ok && !current.Finished() && current.Status != core.FirmwareDeferred {
+		m.Unlock()
+		return
+	}
+	update.Mac = mac
+	update.Status = core.FirmwareDeferred
+	update.Message = reason
+	update.QueuedAt = time.Now().UTC()
+	m.updates[mac] = &update
+	m.Unlock()
+
+	rlog.Infof("Firmware update %v to %v deferred: %v", mac, update.TargetVersion, reason)
+	s.sendFirmwareEvent(update)
+}
+
+//cronFirmware start the deferred updates once the policy allows them
+func (s *Service) cronFirmware() {
+	timer := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-timer.C:
+			allowed, _ := s.updateAllowed(time.Now())
+			if !allowed {
+				continue
+			}
+			for _, update := range s.FirmwareUpdates() {
+				if update.Status == core.FirmwareDeferred {
+					s.scheduleUpdate(update)
+				}
+			}
+		}
+	}
+}
+
+//manualUpdate update a device on request whatever the policy
+func (s *Service) manualUpdate(request core.FirmwareRequest) {
+	rlog.Infof("Manual firmware update requested for %v (version: %v)", request.Mac, request.Version)
+	update, ok := s.prepareUpdate(request.Mac, request.Version)
+	if !ok {
+		rlog.Info("No firmware update needed or device unreachable ", request.Mac)
+		return
+	}
+	s.scheduleUpdate(*update)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+type publishedStatus struct {
+	status dhvac.Hvac
+	date   time.Time
+}
+
+func withinDeadband(previous, current, deadband int) bool {
+	diff := current - previous
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff < deadband
+}
+
+//significantChange compare two status, measures moving less than their deadband are ignored
+func significantChange(previous, current dhvac.Hvac, conf core.PublishConfig) bool {
+	if withinDeadband(previous.SpaceTemp1, current.SpaceTemp1, conf.TemperatureDeadband) {
+		current.SpaceTemp1 = previous.SpaceTemp1
+	}
+	if withinDeadband(previous.SpaceCO2, current.SpaceCO2, conf.CO2Deadband) {
+		current.SpaceCO2 = previous.SpaceCO2
+	}
+	if withinDeadband(previous.SpaceHygro, current.SpaceHygro, conf.HygroDeadband) {
+		current.SpaceHygro = previous.SpaceHygro
+	}
+	prev, _ := previous.ToJSON()
+	cur, _ := current.ToJSON()
+	return prev != cur
+}
+
+//publishOnChange send the status as soon as it differs from the last published one
+func (s *Service) publishOnChange(mac string) {
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		return
+	}
+	driver, err := dhvac.ToHvac(d)
+	if err != nil || !driver.IsConfigured {
+		return
+	}
+	last, ok := s.published.Get(mac)
+	if ok && !significantChange(last.(publishedStatus).status, *driver, s.bridgeConf.Publish) {
+		s.sendLoops(mac, false)
+		return
+	}
+	s.sendDump(*driver)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/drivers"
+)
+
+//deviceToken return the token of the last refresh, a new login is done when none is cached
+func (s *Service) deviceToken(driver drivers.Driver, hvac dhvac.Hvac, renew bool) (string, error) {
+	mac := strings.ToUpper(hvac.Mac)
+	if !renew {
+		if token, ok := s.tokens.Get(mac); ok {
+			return token.(string), nil
+		}
+	}
+	token, err := driver.Login(hvac.IP)
+	if err != nil {
+		s.tokens.Remove(mac)
+		return "", err
+	}
+	s.tokens.Set(mac, token)
+	return token, nil
+}
+
+//RawRequest forward a request to the controller API with the cached device token
+//The request is sent again with a new token when the cached one is refused
+func (s *Service) RawRequest(request core.RawRequest) (*core.RawResponse, error) {
+	d, ok := s.hvacs.Get(strings.ToUpper(request.Mac))
+	if !ok {
+		return nil, NewError("Device " + request.Mac + " not found")
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return nil, err
+	}
+	driver := s.deviceDriver(hvac.Mac)
+	token, err := s.deviceToken(driver, *hvac, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := driver.Raw(hvac.IP, token, request)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	token, err = s.deviceToken(driver, *hvac, true)
+	if err != nil {
+		return nil, err
+	}
+	return driver.Raw(hvac.IP, token, request)
+}
@@ -0,0 +1,425 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+const dateFormat = "2006-01-02"
+
+//scheduleManager occupancy schedules and the entry applied to each device
+//running serializes the runs of applySchedules
+type scheduleManager struct {
+	sync.Mutex
+	running   sync.Mutex
+	path      string
+	schedules map[string]core.Schedule
+	active    map[string]core.ActiveSchedule
+}
+
+func newScheduleManager(path string) (*scheduleManager, error) {
+	m := &scheduleManager{
+		path:      path,
+		schedules: make(map[string]core.Schedule),
+		active:    make(map[string]core.ActiveSchedule),
+	}
+	if path == "" {
+		return m, nil
+	}
+	schedules, err := core.ReadSchedules(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, schedule := range schedules {
+		err := checkSchedule(schedule)
+		if err != nil {
+			return nil, err
+		}
+		m.schedules[schedule.ID] = schedule
+	}
+	return m, nil
+}
+
+//save write the schedules file, the lock must be held
+func (m *scheduleManager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	return core.WriteSchedules(m.path, m.list())
+}
+
+//list return the schedules sorted by id, the lock must be held
+func (m *scheduleManager) list() []core.Schedule {
+	schedules := []core.Schedule{}
+	for _, schedule := range m.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
+//parseClock return the minutes since midnight of a "15:04" time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, NewError("Invalid time " + clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func checkSchedule(schedule core.Schedule) error {
+	if schedule.ID == "" {
+		return NewError("Missing schedule id")
+	}
+	for _, period := range schedule.Periods {
+		if _, err := parseClock(period.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(period.End); err != nil {
+			return err
+		}
+		for _, day := range period.Days {
+			if day < 0 || day > 6 {
+				return NewError("Invalid day " + strconv.Itoa(day) + " in schedule " + schedule.ID)
+			}
+		}
+	}
+	for _, exception := range schedule.Exceptions {
+		from, err := time.Parse(dateFormat, exception.From)
+		if err != nil {
+			return NewError("Invalid date " + exception.From + " in schedule " + schedule.ID)
+		}
+		if exception.To == "" {
+			continue
+		}
+		to, err := time.Parse(dateFormat, exception.To)
+		if err != nil || to.Before(from) {
+			return NewError("Invalid date " + exception.To + " in schedule " + schedule.ID)
+		}
+	}
+	return nil
+}
+
+func hasDay(days []int, day int) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+//periodMatch a period whose end is not after its start lasts until the next day
+func periodMatch(period core.ScheduleWeeklyPeriod, now time.Time) bool {
+	start, _ := parseClock(period.Start)
+	end, _ := parseClock(period.End)
+	minute := now.Hour()*60 + now.Minute()
+	day := int(now.Weekday())
+	if end > start {
+		return hasDay(period.Days, day) && minute >= start && minute < end
+	}
+	if hasDay(period.Days, day) && minute >= start {
+		return true
+	}
+	return hasDay(period.Days, (day+6)%7) && minute < end
+}
+
+func exceptionMatch(exception core.ScheduleExceptionDays, now time.Time) bool {
+	today := now.Format(dateFormat)
+	to := exception.To
+	if to == "" {
+		to = exception.From
+	}
+	return today >= exception.From && today <= to
+}
+
+//actionFields return the mode and setpoints of an action
+func actionFields(action *core.ScheduleAction) []**int {
+	return []**int{
+		&action.TargetMode,
+		&action.SetpointCoolOccupied,
+		&action.SetpointHeatOccupied,
+		&action.SetpointCoolInoccupied,
+		&action.SetpointHeatInoccupied,
+		&action.SetpointCoolStandby,
+		&action.SetpointHeatStandby,
+	}
+}
+
+//confActionFields return the mode and setpoints of a setting in the order of actionFields
+func confActionFields(conf *dhvac.HvacConf) []**int {
+	return []**int{
+		&conf.TargetMode,
+		&conf.SetpointCoolOccupied,
+		&conf.SetpointHeatOccupied,
+		&conf.SetpointCoolInoccupied,
+		&conf.SetpointHeatInoccupied,
+		&conf.SetpointCoolStandby,
+		&conf.SetpointHeatStandby,
+	}
+}
+
+//sameAction compare the values of two actions, an unset value only matches an unset one
+func sameAction(a, b core.ScheduleAction) bool {
+	fieldsA, fieldsB := actionFields(&a), actionFields(&b)
+	for i := range fieldsA {
+		valueA, valueB := *fieldsA[i], *fieldsB[i]
+		if (valueA == nil) != (valueB == nil) || (valueA != nil && *valueA != *valueB) {
+			return false
+		}
+	}
+	return true
+}
+
+func emptyAction(action core.ScheduleAction) bool {
+	return sameAction(action, core.ScheduleAction{})
+}
+
+//scheduleEntry return the entry of a schedule active at a local time
+//Exceptions replace the periods, an exception without action applies the default one
+func scheduleEntry(schedule core.Schedule, now time.Time) (*core.ActiveSchedule, bool) {
+	active := core.ActiveSchedule{
+		ScheduleID: schedule.ID,
+		Entry:      core.ScheduleDefault,
+	}
+	found := false
+	for i, exception := range schedule.Exceptions {
+		if !exceptionMatch(exception, now) {
+			continue
+		}
+		active.Entry = core.ScheduleException
+		active.Index = i
+		active.Name = exception.Name
+		active.Action = exception.ScheduleAction
+		found = true
+		break
+	}
+	if !found {
+		for i, period := range schedule.Periods {
+			if !periodMatch(period, now) {
+				continue
+			}
+			active.Entry = core.SchedulePeriod
+			active.Index = i
+			active.Action = period.ScheduleAction
+			found = true
+			break
+		}
+	}
+	if emptyAction(active.Action) {
+		if schedule.Default == nil {
+			return nil, false
+		}
+		active.Action = *schedule.Default
+	}
+	return &active, true
+}
+
+func scheduleTargets(schedule core.Schedule, hvac dhvac.Hvac) bool {
+	for _, mac := range schedule.Macs {
+		if strings.ToUpper(mac) == strings.ToUpper(hvac.Mac) {
+			return true
+		}
+	}
+	for _, group := range schedule.Groups {
+		if group == hvac.Group {
+			return true
+		}
+	}
+	return false
+}
+
+//deviceEntry return the active entry of the highest priority schedule of a device
+func (m *scheduleManager) deviceEntry(hvac dhvac.Hvac, now time.Time) (*core.ActiveSchedule, bool) {
+	var best *core.ActiveSchedule
+	priority := 0
+	for _, schedule := range m.list() {
+		if !scheduleTargets(schedule, hvac) {
+			continue
+		}
+		if best != nil && schedule.Priority <= priority {
+			continue
+		}
+		entry, ok := scheduleEntry(schedule, now)
+		if !ok {
+			continue
+		}
+		best = entry
+		priority = schedule.Priority
+	}
+	return best, best != nil
+}
+
+func sameEntry(a, b core.ActiveSchedule) bool {
+	return a.ScheduleID == b.ScheduleID && a.Entry == b.Entry && a.Index == b.Index &&
+		sameAction(a.Action, b.Action)
+}
+
+//scheduleConf build the configuration applying a schedule action
+func scheduleConf(mac string, action core.ScheduleAction) dhvac.HvacConf {
+	return dhvac.HvacConf{
+		Mac:                    mac,
+		TargetMode:             action.TargetMode,
+		SetpointCoolOccupied:   action.SetpointCoolOccupied,
+		SetpointHeatOccupied:   action.SetpointHeatOccupied,
+		SetpointCoolInoccupied: action.SetpointCoolInoccupied,
+		SetpointHeatInoccupied: action.SetpointHeatInoccupied,
+		SetpointCoolStandby:    action.SetpointCoolStandby,
+		SetpointHeatStandby:    action.SetpointHeatStandby,
+	}
+}
+
+//applySchedules send the action of the entries which started since the last run
+//The device keeps its last mode when no schedule applies anymore, upstream commands are
+//overridden at the next entry change only
+func (s *Service) applySchedules(now time.Time) {
+	m := s.schedules
+	m.running.Lock()
+	defer m.running.Unlock()
+	for mac, d := range s.hvacs.Items() {
+		hvac, err := dhvac.ToHvac(d)
+		if err != nil {
+			continue
+		}
+		if !hvac.IsConfigured {
+			// applied again once the device is set up
+			m.Lock()
+			delete(m.active, mac)
+			m.Unlock()
+			continue
+		}
+		m.Lock()
+		entry, ok := m.deviceEntry(*hvac, now)
+		last, applied := m.active[mac]
+		if !ok {
+			delete(m.active, mac)
+		}
+		m.Unlock()
+
+		if !ok {
+			if applied {
+				s.sendSchedule(core.ActiveSchedule{Mac: hvac.Mac})
+			}
+			continue
+		}
+		if applied && sameEntry(last, *entry) {
+			continue
+		}
+		entry.Mac = hvac.Mac
+		entry.AppliedAt = now.UTC()
+		m.Lock()
+		m.active[mac] = *entry
+		m.Unlock()
+
+		rlog.Infof("Schedule %v: apply %v %v to %v", entry.ScheduleID, entry.Entry, entry.Index, mac)
+		s.dispatcher.dispatch(mac, deviceEvent{name: EventConf, content: scheduleConf(hvac.Mac, entry.Action)})
+		s.sendSchedule(*entry)
+	}
+}
+
+func (s *Service) sendSchedule(active core.ActiveSchedule) {
+	dump, err := tools.ToJSON(active)
+	if err != nil {
+		rlog.Errorf("Could not dump HVAC %v schedule %v", active.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgStatus, "/read/hvac/"+active.Mac+"/"+net.UrlSchedule, dump)
+}
+
+//sendActiveSchedule publish the active entry of a device along its status, the dhvac status has no field for it
+func (s *Service) sendActiveSchedule(mac string) {
+	m := s.schedules
+	m.Lock()
+	active, ok := m.active[strings.ToUpper(mac)]
+	m.Unlock()
+	if ok {
+		s.sendSchedule(active)
+	}
+}
+
+//cronSchedules check the schedules every minute
+func (s *Service) cronSchedules() {
+	timer := time.NewTicker(time.Minute)
+	s.applySchedules(time.Now())
+	for {
+		select {
+		case <-timer.C:
+			s.applySchedules(time.Now())
+		}
+	}
+}
+
+//Schedules return the occupancy schedules sorted by id
+func (s *Service) Schedules() []core.Schedule {
+	m := s.schedules
+	m.Lock()
+	defer m.Unlock()
+	return m.list()
+}
+
+//SetSchedule create or replace a schedule, it is applied immediately
+func (s *Service) SetSchedule(schedule core.Schedule) error {
+	err := checkSchedule(schedule)
+	if err != nil {
+		return err
+	}
+	m := s.schedules
+	m.Lock()
+	m.schedules[schedule.ID] = schedule
+	err = m.save()
+	m.Unlock()
+	if err != nil {
+		rlog.Error("Cannot save schedules " + err.Error())
+		return err
+	}
+	rlog.Info("Schedule " + schedule.ID + " saved")
+	go s.applySchedules(time.Now())
+	return nil
+}
+
+//DeleteSchedule remove a schedule, false when it does not exist
+func (s *Service) DeleteSchedule(id string) (bool, error) {
+	m := s.schedules
+	m.Lock()
+	if _, ok := m.schedules[id]; !ok {
+		m.Unlock()
+		return false, nil
+	}
+	delete(m.schedules, id)
+	err := m.save()
+	m.Unlock()
+	if err != nil {
+		rlog.Error("Cannot save schedules " + err.Error())
+		return true, err
+	}
+	rlog.Info("Schedule " + id + " deleted")
+	go s.applySchedules(time.Now())
+	return true, nil
+}
+
+//DeviceSchedule return the schedule entry applied to a device, without schedule id when none applies
+func (s *Service) DeviceSchedule(mac string) (*core.ActiveSchedule, bool) {
+	mac = strings.ToUpper(mac)
+	if _, ok := s.hvacs.Get(mac); !ok {
+		return nil, false
+	}
+	m := s.schedules
+	m.Lock()
+	defer m.Unlock()
+	active, ok := m.active[mac]
+	if !ok {
+		active = core.ActiveSchedule{Mac: mac}
+	}
+	return &active, true
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestSameAction(t *testing.T) {
+	tests := []struct {
+		a, b     core.ScheduleAction
+		expected bool
+	}{
+		{a: core.ScheduleAction{}, b: core.ScheduleAction{}, expected: true},
+		{a: core.ScheduleAction{TargetMode: intPtr(1)}, b: core.ScheduleAction{TargetMode: intPtr(1)}, expected: true},
+		{a: core.ScheduleAction{SetpointCoolStandby: intPtr(260)}, b: core.ScheduleAction{SetpointCoolStandby: intPtr(270)}},
+		{a: core.ScheduleAction{SetpointHeatOccupied: intPtr(0)}, b: core.ScheduleAction{}},
+		{a: core.ScheduleAction{}, b: core.ScheduleAction{SetpointHeatInoccupied: intPtr(160)}},
+	}
+	for i, test := range tests {
+		if sameAction(test.a, test.b) != test.expected {
+			t.Errorf("%v: got %v, expected %v", i, !test.expected, test.expected)
+		}
+	}
+	if emptyAction(core.ScheduleAction{TargetMode: intPtr(0)}) {
+		t.Error("action with a mode taken as empty")
+	}
+}
+
+func TestHoldAction(t *testing.T) {
+	previous := core.ScheduleAction{TargetMode: intPtr(1), SetpointCoolOccupied: intPtr(240)}
+	protection := core.ScheduleAction{TargetMode: intPtr(4)}
+	conf := dhvac.HvacConf{TargetMode: intPtr(2), SetpointCoolOccupied: intPtr(250)}
+	held := holdAction(previous, protection, &conf)
+	expected := core.ScheduleAction{TargetMode: intPtr(2), SetpointCoolOccupied: intPtr(240)}
+	if !sameAction(held, expected) {
+		t.Errorf("got %+v, expected %+v", held, expected)
+	}
+	if conf.TargetMode != nil || conf.SetpointCoolOccupied == nil || *conf.SetpointCoolOccupied != 250 {
+		t.Errorf("got %+v, expected only the mode removed", conf)
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/dhvac"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/romana/rlog"
+)
+
+const (
+	schedulerTick = time.Second
+
+	MinDumpFrequency    = 1000 //in milliseconds
+	MinRefreshFrequency = 5000 //in milliseconds
+)
+
+//dumpFrequency return the status heartbeat period of a device in milliseconds
+func (s *Service) dumpFrequency(driver dhvac.Hvac) int {
+	if driver.DumpFrequency > 0 {
+		return driver.DumpFrequency
+	}
+	return s.bridgeConf.Publish.Heartbeat
+}
+
+//refreshFrequency return the polling period of a device in milliseconds
+func (s *Service) refreshFrequency(mac string) int {
+	freq, ok := s.refreshFreqs.Get(strings.ToUpper(mac))
+	if ok {
+		return freq.(int)
+	}
+	return s.bridgeConf.Timing.RefreshFrequency
+}
+
+func elapsed(date interface{}, ok bool, period int) bool {
+	if !ok {
+		return true
+	}
+	return time.Since(date.(time.Time)) >= time.Duration(period)*time.Millisecond
+}
+
+//refreshDue return true when the device must be polled, legacy devices wait for their update
+func (s *Service) refreshDue(mac string) bool {
+	if s.legacies.Has(mac) {
+		return false
+	}
+	last, ok := s.lastRefresh.Get(mac)
+	return elapsed(last, ok, s.refreshFrequency(mac))
+}
+
+func (s *Service) helloDue(driver dhvac.Hvac) bool {
+	if s.legacies.Has(strings.ToUpper(driver.Mac)) {
+		return false
+	}
+	last, ok := s.lastHello.Get(strings.ToUpper(driver.Mac))
+	return elapsed(last, ok, int(s.timerDump))
+}
+
+//heartbeatDue return true when the device status has not been sent for its dump period
+func (s *Service) heartbeatDue(driver dhvac.Hvac) bool {
+	last, ok := s.published.Get(strings.ToUpper(driver.Mac))
+	if !ok {
+		return true
+	}
+	return elapsed(last.(publishedStatus).date, true, s.dumpFrequency(driver))
+}
+
+func (s *Service) setDumpFrequency(hvac *dhvac.Hvac, freq int) {
+	if freq <= 0 {
+		return
+	}
+	if freq < MinDumpFrequency {
+		freq = MinDumpFrequency
+	}
+	hvac.DumpFrequency = freq
+}
+
+func (s *Service) setRefreshFrequency(mac string, freq int) {
+	if freq <= 0 {
+		return
+	}
+	if freq < MinRefreshFrequency {
+		freq = MinRefreshFrequency
+	}
+	mac = strings.ToUpper(mac)
+	if current, ok := s.refreshFreqs.Get(mac); ok && current.(int) == freq {
+		return
+	}
+	s.refreshFreqs.Set(mac, freq)
+	s.saveRefreshFrequencies()
+}
+
+//saveRefreshFrequencies write the per device polling periods so that they survive a restart
+func (s *Service) saveRefreshFrequencies() {
+	if s.bridgeConf.Timing.File == "" {
+		return
+	}
+	s.savingFreqs.Lock()
+	defer s.savingFreqs.Unlock()
+	freqs := make(map[string]int)
+	for mac, freq := range s.refreshFreqs.Items() {
+		freqs[mac] = freq.(int)
+	}
+	err := core.WriteRefreshFrequencies(s.bridgeConf.Timing.File, freqs)
+	if err != nil {
+		rlog.Error("Cannot save refresh frequencies " + err.Error())
+	}
+}
+
+func (s *Service) receivedHvacTiming(mac string, timing core.HvacTiming) {
+	d, ok := s.hvacs.Get(mac)
+	if !ok {
+		rlog.Error("Cannot find hvac ", mac)
+		return
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return
+	}
+	s.setDumpFrequency(hvac, timing.DumpFrequency)
+	s.setRefreshFrequency(mac, timing.RefreshFrequency)
+	s.hvacs.Set(mac, *hvac)
+	rlog.Infof("New timing for %v: dump %v ms, refresh %v ms", mac, s.dumpFrequency(*hvac), s.refreshFrequency(mac))
+}
+
+//DeviceTiming return the refresh and dump intervals of a device
+func (s *Service) DeviceTiming(mac string) (*core.HvacTiming, bool) {
+	d, ok := s.hvacs.Get(strings.ToUpper(mac))
+	if !ok {
+		return nil, false
+	}
+	hvac, err := dhvac.ToHvac(d)
+	if err != nil {
+		return nil, false
+	}
+	return &core.HvacTiming{
+		Mac:              hvac.Mac,
+		DumpFrequency:    s.dumpFrequency(*hvac),
+		RefreshFrequency: s.refreshFrequency(mac),
+	}, true
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/energieip/common-components-go/pkg/tools"
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	net "github.com/energieip/swh200-rest2mqtt-go/internal/network"
+	"github.com/romana/rlog"
+)
+
+//updateManager firmware update campaign state
+//At most Concurrency devices are updated at the same time, the others wait in arrival order
+type updateManager struct {
+	sync.Mutex
+	updates map[string]*core.FirmwareUpdate
+	queue   []string
+	running int
+}
+
+func newUpdateManager() *updateManager {
+	return &updateManager{
+		updates: make(map[string]*core.FirmwareUpdate),
+	}
+}
+
+//targetVersion return the expected software version of a device
+func (s *Service) targetVersion(mac string, productType string) string {
+	for pinnedMac, version := range s.bridgeConf.Firmware.Pinned {
+		if strings.ToUpper(pinnedMac) == strings.ToUpper(mac) && version != "" {
+			return version
+		}
+	}
+	version, ok := s.bridgeConf.Firmware.Targets[productType]
+	if ok && version != "" {
+		return version
+	}
+	return s.conf.ClientAPI.APIVersion
+}
+
+//scheduleUpdate queue a firmware update, it is ignored when one is already in progress
+func (s *Service) scheduleUpdate(update core.FirmwareUpdate) bool {
+	mac := strings.ToUpper(update.Mac)
+	m := s.updates
+	m.Lock()
+	current, ok := m.updates[mac]
+	if ok && !current.Finished() && current.Status != core.FirmwareDeferred {
+		m.Unlock()
+		rlog.Info("Firmware update already in progress for ", mac)
+		return false
+	}
+	update.Mac = mac
+	update.Status = core.FirmwareQueued
+	update.QueuedAt = time.Now().UTC()
+	m.updates[mac] = &update
+	m.queue = append(m.queue, mac)
+	m.Unlock()
+
+	s.sendFirmwareEvent(update)
+	s.nextUpdates()
+	return true
+}
+
+//nextUpdates start the queued updates while slots are available
+func (s *Service) nextUpdates() {
+	m := s.updates
+	m.Lock()
+	defer m.Unlock()
+	slots := s.bridgeConf.Firmware.Concurrency
+	if slots < 1 {
+		slots = 1
+	}
+	for m.running < slots && len(m.queue) > 0 {
+		mac := m.queue[0]
+		m.queue = m.queue[1:]
+		m.running++
+		go s.runUpdate(mac)
+	}
+}
+
+func (s *Service) setUpdateStatus(mac string, status string, message string) core.FirmwareUpdate {
+	m := s.updates
+	m.Lock()
+	update := m.updates[mac]
+	update.Status = status
+	update.Message = message
+	switch status {
+	case core.FirmwareTriggered:
+		update.StartedAt = time.Now().UTC()
+	case core.FirmwareSuccess, core.FirmwareFailed, core.FirmwareTimeout:
+		update.EndedAt = time.Now().UTC()
+	}
+	result := *update
+	m.Unlock()
+
+	rlog.Infof("Firmware update %v: %v %v", mac, status, message)
+	s.sendFirmwareEvent(result)
+	return result
+}
+
+func (s *Service) runUpdate(mac string) {
+	defer func() {
+		m := s.updates
+		m.Lock()
+		m.running--
+		m.Unlock()
+		s.nextUpdates()
+	}()
+
+	m := s.updates
+	m.Lock()
+	update := *m.updates[mac]
+	m.Unlock()
+
+	err := s.deviceDriver(mac).Update(update, s.tftpServerIP())
+	if err != nil {
+		s.setUpdateStatus(mac, core.FirmwareFailed, err.Error())
+		return
+	}
+	s.setUpdateStatus(mac, core.FirmwareTriggered, "TFTP server "+s.tftpServerIP())
+	s.waitUpdate(update)
+}
+
+//waitUpdate poll the device until it reports the target version
+func (s *Service) waitUpdate(update core.FirmwareUpdate) {
+	conf := s.bridgeConf.Firmware
+	deadline := time.Now().Add(time.Duration(conf.Timeout) * time.Millisecond)
+	poll := time.Duration(conf.PollInterval) * time.Millisecond
+	polls := 0
+	for time.Now().Before(deadline) {
+		time.Sleep(poll)
+		polls++
+		driver, info, err := s.registry.Probe(update.IP)
+		if err != nil {
+			s.setUpdateProgress(update.Mac, polls, "")
+			continue
+		}
+		s.setUpdateProgress(update.Mac, polls, info.SoftwareVersion)
+		if info.SoftwareVersion == update.TargetVersion {
+			// the status is only written by the worker of the device
+			s.dispatcher.dispatch(update.Mac, deviceEvent{name: EventDriver, content: driver})
+			s.productTypes.Set(update.Mac, info.ProductType)
+			s.setUpdateStatus(update.Mac, core.FirmwareSuccess, "")
+			return
+		}
+	}
+	s.setUpdateStatus(update.Mac, core.FirmwareTimeout, "Target version "+update.TargetVersion+" not reported")
+}
+
+func (s *Service) setUpdateProgress(mac string, polls int, version string) {
+	m := s.updates
+	m.Lock()
+	update := m.updates[mac]
+	update.Status = core.FirmwareWaiting
+	update.Polls = polls
+	if version != "" {
+		update.CurrentVersion = version
+	}
+	result := *update
+	m.Unlock()
+	s.sendFirmwareEvent(result)
+}
+
+//tftpServerIP return the TFTP server given to the devices, the built-in one when enabled
+func (s *Service) tftpServerIP() string {
+	if s.tftp != nil {
+		return s.tftp.IP()
+	}
+	return s.bridgeConf.Firmware.TftpServer
+}
+
+//onTftpTransfer attach a built-in TFTP transfer to the update of the requesting device
+func (s *Service) onTftpTransfer(transfer core.TftpTransfer) {
+	m := s.updates
+	m.Lock()
+	var found *core.FirmwareUpdate
+	for _, update := range m.updates {
+		if update.IP == transfer.ClientIP && !update.Finished() {
+			found = update
+			break
+		}
+	}
+	if found == nil {
+		m.Unlock()
+		rlog.Warn("TFTP transfer of " + transfer.File + " to " + transfer.ClientIP + " without firmware update")
+		return
+	}
+	found.Transfer = &transfer
+	result := *found
+	m.Unlock()
+	rlog.Infof("Firmware update %v: transfer %v %v/%v bytes", result.Mac, transfer.Status, transfer.Sent, transfer.Size)
+	s.sendFirmwareEvent(result)
+}
+
+func (s *Service) sendFirmwareEvent(update core.FirmwareUpdate) {
+	dump, err := tools.ToJSON(update)
+	if err != nil {
+		rlog.Errorf("Could not dump firmware update %v status %v", update.Mac, err.Error())
+		return
+	}
+	s.local.SendCommand(net.MsgEvents, "/read/hvac/"+update.Mac+"/"+net.UrlFirmware, dump)
+}
+
+//FirmwareUpdates return the firmware updates sorted by mac address
+func (s *Service) FirmwareUpdates() []core.FirmwareUpdate {
+	m := s.updates
+	m.Lock()
+	defer m.Unlock()
+	updates := []core.FirmwareUpdate{}
+	for _, update := range m.updates {
+		updates = append(updates, *update)
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Mac < updates[j].Mac
+	})
+	return updates
+}
+
+//FirmwareUpdate return the last firmware update of a device
+func (s *Service) FirmwareUpdate(mac string) (*core.FirmwareUpdate, bool) {
+	m := s.updates
+	m.Lock()
+	defer m.Unlock()
+	update, ok := m.updates[strings.ToUpper(mac)]
+	if !ok {
+		return nil, false
+	}
+	result := *update
+	return &result, true
+}
+
+//StartCampaign queue an update for the given devices whose version differs from the target
+//An empty list selects every known device
+func (s *Service) StartCampaign(macs []string) []string {
+	if len(macs) == 0 {
+		for mac := range s.hvacs.Items() {
+			macs = append(macs, mac)
+		}
+	}
+	scheduled := []string{}
+	for _, mac := range macs {
+		update, ok := s.prepareUpdate(mac, "")
+		if !ok {
+			continue
+		}
+		if s.scheduleUpdate(*update) {
+			scheduled = append(scheduled, update.Mac)
+		}
+	}
+	return scheduled
+}
+
+//prepareUpdate read the device version and build its update when it is outdated, a legacy
+//device is always updated
+//An empty version selects the configured target
+func (s *Service) prepareUpdate(mac string, version string) (*core.FirmwareUpdate, bool) {
+	d, ok := s.hvacs.Get(strings.ToUpper(mac))
+	if !ok {
+		return nil, false
+	}
+	device, err := core.ToDevice(d)
+	if err != nil || device == nil {
+		return nil, false
+	}
+	if s.legacies.Has(strings.ToUpper(device.Mac)) {
+		target := version
+		if target == "" {
+			target = s.targetVersion(device.Mac, "")
+		}
+		return &core.FirmwareUpdate{
+			Mac:           device.Mac,
+			IP:            device.IP,
+			Legacy:        true,
+			TargetVersion: target,
+		}, true
+	}
+	info, err := s.deviceDriver(device.Mac).Probe(device.IP)
+	if err != nil {
+		return nil, false
+	}
+	target := version
+	if target == "" {
+		target = s.targetVersion(device.Mac, info.ProductType)
+	}
+	if info.SoftwareVersion == target {
+		return nil, false
+	}
+	return &core.FirmwareUpdate{
+		Mac:            device.Mac,
+		IP:             device.IP,
+		ProductType:    info.ProductType,
+		FromVersion:    info.SoftwareVersion,
+		CurrentVersion: info.SoftwareVersion,
+		TargetVersion:  target,
+	}, true
+}
@@ -0,0 +1,303 @@
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/energieip/swh200-rest2mqtt-go/internal/core"
+	"github.com/romana/rlog"
+)
+
+//TFTP opcodes (RFC 1350)
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+
+	errUndefined    = 0
+	errNotFound     = 1
+	errAccess       = 2
+	errIllegalOp    = 4
+	blockSize       = 512
+	maxFileSize     = 65535*blockSize - 1 //the 16 bits block number must not wrap
+	maxRetries      = 5
+	ackTimeout      = 3 * time.Second
+	progressPackets = 256
+)
+
+//Server read-only TFTP server
+type Server struct {
+	ip         net.IP
+	port       int
+	root       string
+	conn       *net.UDPConn
+	OnTransfer func(transfer core.TftpTransfer)
+}
+
+type tftpError struct {
+	s string
+}
+
+func (e *tftpError) Error() string {
+	return e.s
+}
+
+// NewError raise an error
+func NewError(text string) error {
+	return &tftpError{text}
+}
+
+//InterfaceIP return the IP of an interface name or the IP itself
+func InterfaceIP(iface string) (net.IP, error) {
+	ip := net.ParseIP(iface)
+	if ip != nil {
+		return ip, nil
+	}
+	itf, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := itf.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, NewError("No IPv4 address on " + iface)
+}
+
+//NewServer create a TFTP server serving the root folder
+func NewServer(conf core.TftpConfig) (*Server, error) {
+	ip, err := InterfaceIP(conf.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		ip:   ip,
+		port: conf.Port,
+		root: conf.Root,
+	}, nil
+}
+
+//IP return the address the server is bound to
+func (srv *Server) IP() string {
+	return srv.ip.String()
+}
+
+//Run listen for read requests
+func (srv *Server) Run() error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: srv.ip, Port: srv.port})
+	if err != nil {
+		return err
+	}
+	srv.conn = conn
+	rlog.Info("TFTP server listening on " + conn.LocalAddr().String() + " serving " + srv.root)
+	buffer := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
+		packet := make([]byte, n)
+		copy(packet, buffer[:n])
+		go srv.handleRequest(packet, addr)
+	}
+}
+
+//Stop close the server socket
+func (srv *Server) Stop() {
+	if srv.conn != nil {
+		srv.conn.Close()
+	}
+}
+
+func (srv *Server) handleRequest(packet []byte, addr *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: srv.ip})
+	if err != nil {
+		rlog.Error("TFTP cannot open transfer socket " + err.Error())
+		return
+	}
+	defer conn.Close()
+
+	if len(packet) < 4 {
+		return
+	}
+	opcode := binary.BigEndian.Uint16(packet)
+	if opcode == opWRQ {
+		rlog.Warn("TFTP write request refused from " + addr.String())
+		sendError(conn, addr, errAccess, "Read only server")
+		return
+	}
+	if opcode != opRRQ {
+		sendError(conn, addr, errIllegalOp, "Illegal operation")
+		return
+	}
+	fields := bytes.Split(packet[2:], []byte{0})
+	filename := string(fields[0])
+
+	path, ok := srv.resolve(filename)
+	if !ok {
+		rlog.Warn("TFTP access refused to " + filename + " from " + addr.String())
+		sendError(conn, addr, errAccess, "Access violation")
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		rlog.Warn("TFTP file " + filename + " requested by " + addr.String() + " not found")
+		sendError(conn, addr, errNotFound, "File not found")
+		return
+	}
+	defer file.Close()
+
+	transfer := core.TftpTransfer{
+		ClientIP:  addr.IP.String(),
+		File:      filename,
+		Status:    core.TransferRunning,
+		StartedAt: time.Now().UTC(),
+	}
+	if info, err := file.Stat(); err == nil {
+		transfer.Size = info.Size()
+	}
+	if transfer.Size > maxFileSize {
+		rlog.Warn("TFTP file " + filename + " requested by " + addr.String() + " too large (" + strconv.FormatInt(transfer.Size, 10) + " bytes)")
+		sendError(conn, addr, errUndefined, "File too large")
+		return
+	}
+	rlog.Info("TFTP start sending " + filename + " to " + addr.String())
+	srv.notify(transfer)
+
+	err = srv.send(conn, addr, file, &transfer)
+	transfer.EndedAt = time.Now().UTC()
+	if err != nil {
+		transfer.Status = core.TransferFailed
+		transfer.Message = err.Error()
+		rlog.Error("TFTP transfer of " + filename + " to " + addr.String() + " failed: " + err.Error())
+	} else {
+		transfer.Status = core.TransferDone
+		rlog.Info("TFTP sent " + filename + " to " + addr.String() + " (" + strconv.FormatInt(transfer.Sent, 10) + " bytes)")
+	}
+	srv.notify(transfer)
+}
+
+//resolve return the file path inside the root folder, symbolic links must stay inside it
+func (srv *Server) resolve(filename string) (string, bool) {
+	clean := filepath.Clean("/" + strings.Replace(filename, "\\", "/", -1))
+	if clean == "/" {
+		return "", false
+	}
+	path := filepath.Join(srv.root, clean)
+	real, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		// reported as not found when opened
+		return path, true
+	}
+	if err != nil {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(srv.root)
+	if err != nil {
+		return "", false
+	}
+	if !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", false
+	}
+	return real, true
+}
+
+func (srv *Server) send(conn *net.UDPConn, addr *net.UDPAddr, file io.Reader, transfer *core.TftpTransfer) error {
+	data := make([]byte, blockSize)
+	ack := make([]byte, 516)
+	block := uint16(1)
+	packets := 0
+	for {
+		n, err := io.ReadFull(file, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			sendError(conn, addr, errAccess, "Read error")
+			return err
+		}
+		packet := make([]byte, 4+n)
+		binary.BigEndian.PutUint16(packet, opDATA)
+		binary.BigEndian.PutUint16(packet[2:], block)
+		copy(packet[4:], data[:n])
+
+		acked := false
+		for retry := 0; retry < maxRetries && !acked; retry++ {
+			_, err = conn.WriteToUDP(packet, addr)
+			if err != nil {
+				return err
+			}
+			acked, err = waitAck(conn, addr, ack, block)
+			if err != nil {
+				return err
+			}
+		}
+		if !acked {
+			return NewError("No acknowledgement for block " + strconv.Itoa(int(block)))
+		}
+		transfer.Sent += int64(n)
+		packets++
+		if packets%progressPackets == 0 {
+			srv.notify(*transfer)
+		}
+		if n < blockSize {
+			return nil
+		}
+		block++
+		if block == 0 {
+			sendError(conn, addr, errUndefined, "File too large")
+			return NewError("Block number wrapped")
+		}
+	}
+}
+
+func waitAck(conn *net.UDPConn, addr *net.UDPAddr, buffer []byte, block uint16) (bool, error) {
+	conn.SetReadDeadline(time.Now().Add(ackTimeout))
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if !from.IP.Equal(addr.IP) || from.Port != addr.Port || n < 4 {
+			continue
+		}
+		switch binary.BigEndian.Uint16(buffer) {
+		case opACK:
+			if binary.BigEndian.Uint16(buffer[2:]) == block {
+				return true, nil
+			}
+		case opERROR:
+			return false, NewError("Client error: " + string(bytes.TrimRight(buffer[4:n], "\x00")))
+		}
+	}
+}
+
+func sendError(conn *net.UDPConn, addr *net.UDPAddr, code uint16, message string) {
+	packet := make([]byte, 4, 5+len(message))
+	binary.BigEndian.PutUint16(packet, opERROR)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(packet, message...)
+	packet = append(packet, 0)
+	conn.WriteToUDP(packet, addr)
+}
+
+func (srv *Server) notify(transfer core.TftpTransfer) {
+	if srv.OnTransfer != nil {
+		srv.OnTransfer(transfer)
+	}
+}
@@ -0,0 +1,61 @@
+package tftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "secret.bin")
+	for _, path := range []string{filepath.Join(root, "images", "hvac.bin"), outside} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside.bin":  filepath.Join(root, "images", "hvac.bin"),
+		"outside.bin": outside,
+		"parent":      dir,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := &Server{root: root}
+	tests := []struct {
+		filename string
+		ok       bool
+	}{
+		{filename: "images/hvac.bin", ok: true},
+		{filename: "images\\hvac.bin", ok: true},
+		{filename: "../secret.bin", ok: true},
+		{filename: "inside.bin", ok: true},
+		{filename: "outside.bin"},
+		{filename: "parent/secret.bin"},
+		{filename: "/"},
+		{filename: "missing.bin", ok: true},
+	}
+	for _, test := range tests {
+		path, ok := srv.resolve(test.filename)
+		if ok != test.ok {
+			t.Errorf("%v: got %v (%v), expected %v", test.filename, ok, path, test.ok)
+		}
+		if ok && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			t.Errorf("%v: got %v outside of the root", test.filename, path)
+		}
+	}
+}